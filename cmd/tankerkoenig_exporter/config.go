@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config is the configuration file given by --config.file. Its options
+// correspond to the flags of the same name.
+type config struct {
+	Tankerkoenig tankerkoenigConfig `yaml:"tankerkoenig"`
+	Web          webConfig          `yaml:"web"`
+}
+
+// tankerkoenigConfig holds the options of the tankerkoenig flags.
+type tankerkoenigConfig struct {
+	APIKey   string        `yaml:"api_key"`
+	Stations []string      `yaml:"stations"`
+	Location string        `yaml:"location"`
+	Radius   int           `yaml:"radius"`
+	Product  string        `yaml:"product"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// webConfig holds the options of the web flags.
+type webConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	TelemetryPath string `yaml:"telemetry_path"`
+}
+
+// readConfigFile reads the configuration file at the given path. Unknown
+// options are rejected.
+func readConfigFile(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// flags returns the options set in the configuration, keyed by the name of the
+// corresponding flag.
+func (c *config) flags() map[string]string {
+	flags := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			flags[name] = value
+		}
+	}
+
+	set("tankerkoenig.api-key", c.Tankerkoenig.APIKey)
+	set("tankerkoenig.stations", strings.Join(c.Tankerkoenig.Stations, ","))
+	set("tankerkoenig.location", c.Tankerkoenig.Location)
+	if c.Tankerkoenig.Radius != 0 {
+		set("tankerkoenig.radius", strconv.Itoa(c.Tankerkoenig.Radius))
+	}
+	set("tankerkoenig.product", c.Tankerkoenig.Product)
+	if c.Tankerkoenig.Timeout != 0 {
+		set("tankerkoenig.timeout", c.Tankerkoenig.Timeout.String())
+	}
+	set("web.listen-address", c.Web.ListenAddress)
+	set("web.telemetry-path", c.Web.TelemetryPath)
+
+	return flags
+}
+
+// apply sets the flags to the options of the configuration. Flags given on the
+// command line take precedence and are left untouched.
+func (c *config) apply() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, value := range c.flags() {
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter"
+)
+
+// dryRun scrapes the Tankerkoenig API once with the given exporter and prints
+// the prices of the monitored stations to w. It returns an error if the scrape
+// failed, the cause of which is logged by the exporter.
+func dryRun(ctx context.Context, w io.Writer, e *exporter.Exporter) error {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(e.WithContext(ctx)); err != nil {
+		return err
+	}
+	if _, err := reg.Gather(); err != nil {
+		return err
+	}
+
+	// Prices are only known after a successful scrape.
+	stations, updated := e.Prices()
+	if updated.IsZero() {
+		return errors.New("scrape failed")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tBRAND\tOPEN\tE5\tE10\tDIESEL")
+	for _, s := range stations {
+		open := "no"
+		if s.Open {
+			open = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Name, s.Brand, open,
+			dryRunPrice(s, "e5"), dryRunPrice(s, "e10"), dryRunPrice(s, "diesel"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d stations, prices of %s\n", len(stations), updated.Format(time.RFC3339))
+	return err
+}
+
+// dryRunPrice formats the price of the given product of the station, or "-" if
+// it has none.
+func dryRunPrice(s exporter.StationPrices, product string) string {
+	if v, ok := s.Prices[product]; ok {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return "-"
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter"
+)
+
+// flags are the values of the command line flags.
+type flags struct {
+	versionFlag       bool
+	tkAPIKey          string
+	tkStations        []string
+	tkStationsFile    string
+	tkNameMap         string
+	tkExclude         []string
+	tkBrands          []string
+	tkRequireStations bool
+	tkLocation        string
+	tkLat             float64
+	tkLng             float64
+	tkRadius          int
+	tkProduct         string
+	tkPriceUnit       string
+	tkSort            string
+	tkPricePrecision  int
+	tkTimeout         time.Duration
+	tkScrapeTimeout   time.Duration
+	tkRetries         int
+	tkRateLimit       float64
+	tkBreakerN        int
+	tkBreakerCooldown time.Duration
+	tkDetailConc      int
+	tkBatchSize       int
+	tkMaxConc         int
+	tkMetaRefresh     time.Duration
+	tkLocRefresh      time.Duration
+	tkPollInterval    time.Duration
+	tkBaseURL         string
+	tkUserAgent       string
+	tkProxyURL        string
+	tkCacheDir        string
+	tkDropMissing     int
+	tkBaseline        int
+	tkMaxIdleConns    int
+	tkIdleTimeout     time.Duration
+	tkNoHTTP2         bool
+	webListenAddrs    []string
+	webTelemetryPath  string
+	webConfigFile     string
+	webLandingPage    string
+	webRuntime        bool
+	webPprof          bool
+	webExposeSecrets  bool
+	webProbe          bool
+	webProbeTTL       time.Duration
+	webProbeMax       int
+	webShutdown       time.Duration
+	configFile        string
+	metricNamespace   string
+	metricNoDetails   bool
+	metricRawNames    bool
+	metricNativeHist  bool
+	metricPriceLabels []string
+	pushGatewayURL    string
+	pushJob           string
+	dryRunFlag        bool
+	onceFlag          bool
+	tracingEnabled    bool
+	logFormat         string
+	logLevel          string
+
+	// latSet and lngSet report whether the coordinates were given explicitly,
+	// as zero is a valid coordinate.
+	latSet, lngSet bool
+}
+
+// hasCoordinates reports whether the location is given by its coordinates.
+func (f *flags) hasCoordinates() bool {
+	return f.latSet || f.lngSet
+}
+
+// hasLocation reports whether a location to search for stations is given.
+func (f *flags) hasLocation() bool {
+	return len(f.tkLocation) > 0 || f.hasCoordinates()
+}
+
+// flagError is an invalid flag value or combination of flags. The hints tell
+// how to fix it.
+type flagError struct {
+	msg   string
+	hints []string
+}
+
+// Error implements error.
+func (e *flagError) Error() string {
+	return e.msg
+}
+
+// invalid returns a flagError with the given message and hints.
+func invalid(msg string, hints ...string) *flagError {
+	return &flagError{msg: msg, hints: hints}
+}
+
+// validateFlags validates the given flags and returns a *flagError for the
+// first invalid flag value or combination of flags. Flags that require access
+// to the file system or the network are validated separately.
+func validateFlags(f *flags) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.logLevel)); err != nil {
+		return invalid("invalid log level", "--log.level must be one of debug, info, warn or error")
+	}
+	if f.logFormat != "logfmt" && f.logFormat != "json" {
+		return invalid("invalid log format", "--log.format must be one of logfmt or json")
+	}
+
+	if len(f.tkAPIKey) == 0 {
+		return invalid("missing api key", "did you forget to export TANKERKOENIG_API_KEY?")
+	}
+	for _, address := range f.webListenAddrs {
+		if len(address) == 0 {
+			return invalid("missing listen address", "did you forget to specify --web.listen-address?")
+		}
+	}
+	if len(f.webTelemetryPath) == 0 {
+		return invalid("missing telemetry path", "did you forget to specify --web.telemetry-path?")
+	}
+
+	if err := validateMode(f); err != nil {
+		return err
+	}
+
+	if !metricNamespaceRE.MatchString(f.metricNamespace) {
+		return invalid("invalid metric namespace", "--metric.namespace must consist of letters, digits and underscores and must not start with a digit")
+	}
+	seenLabels := make(map[string]bool, len(f.metricPriceLabels))
+	for _, label := range f.metricPriceLabels {
+		if !slices.Contains(exporter.PriceLabels, label) {
+			return invalid(fmt.Sprintf("invalid price label %q", label), "--metric.price-labels must only include id, product, name, city and brand")
+		} else if seenLabels[label] {
+			return invalid(fmt.Sprintf("duplicate price label %q", label))
+		}
+		seenLabels[label] = true
+	}
+	if !seenLabels["id"] || !seenLabels["product"] {
+		return invalid("missing price label", "--metric.price-labels must include id and product")
+	}
+
+	if f.tkProduct != "e5" && f.tkProduct != "e10" && f.tkProduct != "diesel" && f.tkProduct != "all" {
+		return invalid("invalid product", "--tankerkoenig.product must be one of e5, e10, diesel or all")
+	}
+	if f.tkSort != "dist" && f.tkSort != "price" {
+		return invalid("invalid sort order", "--tankerkoenig.sort must be one of dist or price")
+	} else if f.tkSort == "price" && f.tkProduct == "all" {
+		return invalid("cannot sort by price of all products", "set --tankerkoenig.product to e5, e10 or diesel to sort by its price")
+	}
+	if f.tkPriceUnit != "euro" && f.tkPriceUnit != "cent" {
+		return invalid("invalid price unit", "--tankerkoenig.price-unit must be one of euro or cent")
+	}
+	if f.tkPricePrecision < 0 || f.tkPricePrecision > 6 {
+		return invalid("invalid price precision", "--tankerkoenig.price-precision must be between 0 and 6")
+	}
+	if f.tkTimeout < time.Second {
+		return invalid("invalid timeout", "--tankerkoenig.timeout must be at least 1s")
+	}
+	if f.tkRetries < 0 {
+		return invalid("invalid retries", "--tankerkoenig.retries must not be negative")
+	}
+	if f.tkRateLimit < 0 {
+		return invalid("invalid rate limit", "--tankerkoenig.rate-limit must not be negative")
+	}
+	if f.tkBreakerN < 0 {
+		return invalid("invalid circuit breaker threshold", "--tankerkoenig.circuit-breaker-threshold must not be negative")
+	}
+	if f.tkBreakerN > 0 && f.tkBreakerCooldown <= 0 {
+		return invalid("invalid circuit breaker cooldown", "--tankerkoenig.circuit-breaker-cooldown must be positive")
+	}
+	if f.tkBatchSize < 1 || f.tkBatchSize > exporter.MaxBatchSize {
+		return invalid("invalid batch size", fmt.Sprintf("--tankerkoenig.batch-size must be between 1 and %d", exporter.MaxBatchSize))
+	}
+	if f.tkMaxConc < 1 {
+		return invalid("invalid max concurrency", "--tankerkoenig.max-concurrency must be positive")
+	}
+	if f.tkDetailConc < 1 {
+		return invalid("invalid detail concurrency", "--tankerkoenig.detail-concurrency must be positive")
+	}
+	if f.tkMaxIdleConns < 0 {
+		return invalid("invalid max idle connections", "--tankerkoenig.max-idle-conns must not be negative")
+	}
+	if f.tkIdleTimeout < 0 {
+		return invalid("invalid idle connection timeout", "--tankerkoenig.idle-conn-timeout must not be negative")
+	}
+	if f.tkBaseline < 0 {
+		return invalid("invalid baseline window", "--tankerkoenig.baseline-window must not be negative")
+	}
+	if f.tkDropMissing < 0 {
+		return invalid("invalid drop missing after", "--tankerkoenig.drop-missing-after must not be negative")
+	}
+	if f.tkMetaRefresh < 0 {
+		return invalid("invalid metadata refresh interval", "--tankerkoenig.metadata-refresh must not be negative")
+	}
+	if f.tkLocRefresh < 0 {
+		return invalid("invalid location refresh interval", "--tankerkoenig.location-refresh must not be negative")
+	}
+	if f.tkPollInterval < 0 {
+		return invalid("invalid poll interval", "--tankerkoenig.poll-interval must not be negative")
+	}
+	if f.tkScrapeTimeout < 0 {
+		return invalid("invalid scrape timeout", "--tankerkoenig.scrape-timeout must not be negative")
+	}
+	if f.webProbeTTL <= 0 {
+		return invalid("invalid probe cache ttl", "--web.probe-cache-ttl must be positive")
+	}
+	if f.webProbeMax < 1 {
+		return invalid("invalid probe max targets", "--web.probe-max-targets must be positive")
+	}
+	if f.webShutdown <= 0 {
+		return invalid("invalid shutdown timeout", "--web.shutdown-timeout must be positive")
+	}
+	if len(f.tkBaseURL) > 0 {
+		if u, err := url.Parse(f.tkBaseURL); err != nil || !u.IsAbs() || u.Host == "" {
+			return invalid("invalid base url", "--tankerkoenig.base-url must be an absolute url like http://localhost:8080/")
+		}
+	}
+	if len(f.tkProxyURL) > 0 {
+		if u, err := url.Parse(f.tkProxyURL); err != nil || u.Host == "" ||
+			(u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return invalid("invalid proxy url", "--tankerkoenig.proxy-url must be an absolute http, https or socks5 url like http://proxy:3128")
+		}
+	}
+
+	return nil
+}
+
+// validateMode validates the flags selecting the stations and how the exporter
+// runs, which depend on each other.
+func validateMode(f *flags) error {
+	if f.hasCoordinates() {
+		if len(f.tkLocation) > 0 {
+			return invalid("--tankerkoenig.lat and --tankerkoenig.lng can't be used with --tankerkoenig.location")
+		}
+		if !f.latSet || !f.lngSet {
+			return invalid("incomplete coordinates", "--tankerkoenig.lat and --tankerkoenig.lng must be given together")
+		}
+		if f.tkLat < -90 || f.tkLat > 90 {
+			return invalid("invalid latitude", "--tankerkoenig.lat must be between -90 and 90")
+		}
+		if f.tkLng < -180 || f.tkLng > 180 {
+			return invalid("invalid longitude", "--tankerkoenig.lng must be between -180 and 180")
+		}
+	}
+
+	if f.hasLocation() {
+		if f.tkRadius < 1 || f.tkRadius > exporter.MaxRadius {
+			return invalid("invalid radius", fmt.Sprintf("--tankerkoenig.radius must be between 1 and %d km", exporter.MaxRadius))
+		}
+	} else {
+		if f.tkLocRefresh > 0 {
+			return invalid("--tankerkoenig.location-refresh requires a location")
+		}
+		if len(f.tkExclude) > 0 {
+			return invalid("--tankerkoenig.exclude requires a location")
+		}
+	}
+
+	// Probing is the only mode that works without stations or a location.
+	if !f.hasLocation() && len(f.tkStations) == 0 {
+		switch {
+		case !f.webProbe:
+			return invalid("missing stations or location", "specify --tankerkoenig.stations or --tankerkoenig.location, or --web.enable-probe to only serve /probe")
+		case len(f.pushGatewayURL) > 0:
+			return invalid("missing stations or location", "--push.gateway-url requires stations or a location to push the metrics of")
+		case f.dryRunFlag:
+			return invalid("missing stations or location", "--dry-run requires stations or a location to validate")
+		case f.onceFlag:
+			return invalid("missing stations or location", "--once requires stations or a location to print the metrics of")
+		}
+	}
+
+	if len(f.pushGatewayURL) > 0 {
+		if u, err := url.Parse(f.pushGatewayURL); err != nil || !u.IsAbs() || u.Host == "" {
+			return invalid("invalid pushgateway url", "--push.gateway-url must be an absolute url like http://localhost:9091")
+		}
+		if len(f.pushJob) == 0 {
+			return invalid("missing push job", "did you forget to specify --push.job?")
+		}
+		if f.tkPollInterval > 0 {
+			return invalid("--tankerkoenig.poll-interval can't be used with --push.gateway-url")
+		}
+	}
+	if f.onceFlag {
+		if len(f.pushGatewayURL) > 0 {
+			return invalid("--once can't be used with --push.gateway-url")
+		}
+		if f.dryRunFlag {
+			return invalid("--once can't be used with --dry-run")
+		}
+		if f.tkPollInterval > 0 {
+			return invalid("--tankerkoenig.poll-interval can't be used with --once")
+		}
+	}
+	if f.dryRunFlag {
+		if len(f.pushGatewayURL) > 0 {
+			return invalid("--dry-run can't be used with --push.gateway-url")
+		}
+		if f.tkPollInterval > 0 {
+			return invalid("--tankerkoenig.poll-interval can't be used with --dry-run")
+		}
+	}
+
+	return nil
+}
+
+// effectiveConfig is the effective configuration of the exporter, as served by
+// /config. Durations are formatted like the flags take them.
+type effectiveConfig struct {
+	Mode            string   `json:"mode"`
+	APIKey          string   `json:"api_key,omitempty"`
+	Stations        int      `json:"stations"`
+	Location        string   `json:"location,omitempty"`
+	Lat             *float64 `json:"lat,omitempty"`
+	Lng             *float64 `json:"lng,omitempty"`
+	Radius          int      `json:"radius,omitempty"`
+	Product         string   `json:"product"`
+	Sort            string   `json:"sort"`
+	PriceUnit       string   `json:"price_unit"`
+	Timeout         string   `json:"timeout"`
+	ScrapeTimeout   string   `json:"scrape_timeout"`
+	MetadataRefresh string   `json:"metadata_refresh"`
+	LocationRefresh string   `json:"location_refresh"`
+	PollInterval    string   `json:"poll_interval"`
+	BaseURL         string   `json:"base_url,omitempty"`
+	ProxyURL        string   `json:"proxy_url,omitempty"`
+	ListenAddresses []string `json:"listen_addresses"`
+	TelemetryPath   string   `json:"telemetry_path"`
+	MetricNamespace string   `json:"metric_namespace"`
+}
+
+// effective returns the effective configuration given by the flags. The API key
+// is left out and the password of the proxy URL is redacted, unless secrets are
+// exposed.
+func (f *flags) effective(exposeSecrets bool) effectiveConfig {
+	c := effectiveConfig{
+		Mode:            "probe",
+		Stations:        len(f.tkStations),
+		Location:        f.tkLocation,
+		Product:         f.tkProduct,
+		Sort:            f.tkSort,
+		PriceUnit:       f.tkPriceUnit,
+		Timeout:         f.tkTimeout.String(),
+		ScrapeTimeout:   f.tkScrapeTimeout.String(),
+		MetadataRefresh: f.tkMetaRefresh.String(),
+		LocationRefresh: f.tkLocRefresh.String(),
+		PollInterval:    f.tkPollInterval.String(),
+		BaseURL:         f.tkBaseURL,
+		ListenAddresses: f.webListenAddrs,
+		TelemetryPath:   f.webTelemetryPath,
+		MetricNamespace: f.metricNamespace,
+	}
+	switch {
+	case f.hasLocation() && len(f.tkStations) > 0:
+		c.Mode = "combined"
+	case f.hasLocation():
+		c.Mode = "location"
+	case len(f.tkStations) > 0:
+		c.Mode = "stations"
+	}
+	if f.hasLocation() {
+		c.Radius = f.tkRadius
+	}
+	if f.hasCoordinates() {
+		c.Lat, c.Lng = &f.tkLat, &f.tkLng
+	}
+
+	if exposeSecrets {
+		c.APIKey = f.tkAPIKey
+		c.ProxyURL = f.tkProxyURL
+	} else if u, err := url.Parse(f.tkProxyURL); err == nil && len(f.tkProxyURL) > 0 {
+		c.ProxyURL = u.Redacted()
+	}
+
+	return c
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/client"
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter"
+)
+
+// defaultFlags returns the default values of the command line flags plus an API
+// key, with the given modifications applied. No mode is selected.
+func defaultFlags(modify func(f *flags)) *flags {
+	f := &flags{
+		tkAPIKey:          "key",
+		tkRadius:          10,
+		tkRequireStations: true,
+		tkProduct:         "all",
+		tkSort:            "dist",
+		tkPriceUnit:       "euro",
+		tkPricePrecision:  3,
+		tkTimeout:         time.Second * 15,
+		tkRetries:         2,
+		tkBreakerN:        5,
+		tkBreakerCooldown: time.Minute,
+		tkBatchSize:       exporter.MaxBatchSize,
+		tkMaxConc:         4,
+		tkDetailConc:      4,
+		tkMaxIdleConns:    client.DefaultMaxIdleConnsPerHost,
+		tkIdleTimeout:     client.DefaultIdleConnTimeout,
+		webListenAddrs:    []string{":9386"},
+		webTelemetryPath:  "/metrics",
+		webProbeTTL:       time.Hour,
+		webProbeMax:       100,
+		webShutdown:       time.Second * 5,
+		metricNamespace:   exporter.DefaultNamespace,
+		metricPriceLabels: []string{"id", "product"},
+		pushJob:           "tankerkoenig_exporter",
+		logFormat:         "logfmt",
+		logLevel:          "info",
+	}
+	if modify != nil {
+		modify(f)
+	}
+	return f
+}
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(f *flags)
+		err    string
+	}{
+		{
+			name: "stations",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+			},
+		},
+		{
+			name: "location",
+			modify: func(f *flags) {
+				f.tkLocation = "u0yjje785f4"
+			},
+		},
+		{
+			name: "coordinates",
+			modify: func(f *flags) {
+				f.tkLat, f.tkLng = 52.52, 13.405
+				f.latSet, f.lngSet = true, true
+			},
+		},
+		{
+			name: "stations and location",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkLocation = "u0yjje785f4"
+			},
+		},
+		{
+			name: "probe only",
+			modify: func(f *flags) {
+				f.webProbe = true
+			},
+		},
+		{
+			name: "no mode",
+			err:  "missing stations or location",
+		},
+		{
+			name: "no mode with once",
+			modify: func(f *flags) {
+				f.webProbe = true
+				f.onceFlag = true
+			},
+			err: "missing stations or location",
+		},
+		{
+			name: "location and coordinates",
+			modify: func(f *flags) {
+				f.tkLocation = "u0yjje785f4"
+				f.tkLat, f.tkLng = 52.52, 13.405
+				f.latSet, f.lngSet = true, true
+			},
+			err: "--tankerkoenig.lat and --tankerkoenig.lng can't be used with --tankerkoenig.location",
+		},
+		{
+			name: "incomplete coordinates",
+			modify: func(f *flags) {
+				f.tkLat = 52.52
+				f.latSet = true
+			},
+			err: "incomplete coordinates",
+		},
+		{
+			name: "invalid latitude",
+			modify: func(f *flags) {
+				f.tkLat, f.tkLng = 91, 13.405
+				f.latSet, f.lngSet = true, true
+			},
+			err: "invalid latitude",
+		},
+		{
+			name: "radius 0 in location mode",
+			modify: func(f *flags) {
+				f.tkLocation = "u0yjje785f4"
+				f.tkRadius = 0
+			},
+			err: "invalid radius",
+		},
+		{
+			name: "radius too large in location mode",
+			modify: func(f *flags) {
+				f.tkLocation = "u0yjje785f4"
+				f.tkRadius = exporter.MaxRadius + 1
+			},
+			err: "invalid radius",
+		},
+		{
+			name: "radius 0 in station mode",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkRadius = 0
+			},
+		},
+		{
+			name: "exclude without location",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkExclude = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+			},
+			err: "--tankerkoenig.exclude requires a location",
+		},
+		{
+			name: "empty api key",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkAPIKey = ""
+			},
+			err: "missing api key",
+		},
+		{
+			name: "invalid product",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkProduct = "lpg"
+			},
+			err: "invalid product",
+		},
+		{
+			name: "sort by price of all products",
+			modify: func(f *flags) {
+				f.tkLocation = "u0yjje785f4"
+				f.tkSort = "price"
+			},
+			err: "cannot sort by price of all products",
+		},
+		{
+			name: "missing price label",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.metricPriceLabels = []string{"id"}
+			},
+			err: "missing price label",
+		},
+		{
+			name: "once with push",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.pushGatewayURL = "http://localhost:9091"
+				f.onceFlag = true
+			},
+			err: "--once can't be used with --push.gateway-url",
+		},
+		{
+			name: "poll interval with dry run",
+			modify: func(f *flags) {
+				f.tkStations = []string{"51d4b55e-a095-1aa0-e100-80009459e03a"}
+				f.tkPollInterval = time.Minute
+				f.dryRunFlag = true
+			},
+			err: "--tankerkoenig.poll-interval can't be used with --dry-run",
+		},
+		{
+			name: "invalid probe max targets",
+			modify: func(f *flags) {
+				f.webProbe = true
+				f.webProbeMax = 0
+			},
+			err: "invalid probe max targets",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFlags(defaultFlags(tt.modify))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("validateFlags() = %v, want no error", err)
+				}
+				return
+			}
+
+			var flagErr *flagError
+			if !errors.As(err, &flagErr) {
+				t.Fatalf("validateFlags() = %v, want *flagError", err)
+			}
+			if flagErr.msg != tt.err {
+				t.Errorf("validateFlags() = %q, want %q", flagErr.msg, tt.err)
+			}
+		})
+	}
+}
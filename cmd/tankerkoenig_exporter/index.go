@@ -0,0 +1,74 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter"
+)
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"price": func(s exporter.StationPrices, product string) string {
+		if v, ok := s.Prices[product]; ok {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return "-"
+	},
+}).Parse(`<html>
+<head><title>Tankerkoenig API Exporter</title></head>
+<body>
+<h1>Tankerkoenig API Exporter</h1>
+<p><a href="{{.TelemetryPath}}">Metrics</a></p>
+{{- if .Monitoring}}
+{{- if .Updated.IsZero}}
+<p>Waiting for first scrape.</p>
+{{- else}}
+<p>Prices of {{.Updated.Format "2006-01-02 15:04:05 MST"}}:</p>
+<table>
+<tr><th>Name</th><th>Brand</th><th>Open</th><th>E5</th><th>E10</th><th>Diesel</th></tr>
+{{- range .Stations}}
+<tr><td>{{.Name}}</td><td>{{.Brand}}</td><td>{{if .Open}}yes{{else}}no{{end}}</td><td>{{price . "e5"}}</td><td>{{price . "e10"}}</td><td>{{price . "diesel"}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// landingPageHandler serves the given custom landing page instead of the
+// built-in one.
+func landingPageHandler(page []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(page)
+	})
+}
+
+// indexHandler serves the landing page. It links the metrics and shows the
+// prices of the monitored stations from the last scrape, if any, without
+// contacting the API.
+func indexHandler(telemetryPath string, e *exporter.Exporter, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		data := struct {
+			TelemetryPath string
+			Monitoring    bool
+			Stations      []exporter.StationPrices
+			Updated       time.Time
+		}{
+			TelemetryPath: telemetryPath,
+			Monitoring:    e != nil,
+		}
+		if e != nil {
+			data.Stations, data.Updated = e.Prices()
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := indexTemplate.Execute(w, data); err != nil {
+			logger.Error("cannot render landing page", "err", err)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStringSliceValue_Set(t *testing.T) {
+	var values []string
+	v := newStringSliceValue(&values)
+	for _, s := range []string{"a", "b,c", "d"} {
+		if err := v.Set(s); err != nil {
+			t.Fatalf("Set(%q) = %v", s, err)
+		}
+	}
+
+	if want := []string{"a", "b", "c", "d"}; !slices.Equal(values, want) {
+		t.Errorf("values = %q, want %q", values, want)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/client"
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter"
+)
+
+// probeTarget is an exporter created for a target given to the probe handler.
+type probeTarget struct {
+	handler  http.Handler
+	cancel   context.CancelFunc
+	lastUsed time.Time
+}
+
+// errTooManyTargets is returned by prober.target if the maximum amount of
+// targets is cached already.
+var errTooManyTargets = errors.New("too many probe targets")
+
+// prober serves scrapes of targets given by query parameters, similar to the
+// blackbox_exporter. The exporters created for the targets are cached, so the
+// station details aren't retrieved on every scrape. Exporters that weren't
+// used for longer than the TTL are discarded by run. As every target costs API
+// requests, at most maxTargets are cached and probes of further targets are
+// rejected.
+type prober struct {
+	ctx        context.Context
+	logger     *slog.Logger
+	client     *client.Client
+	product    string
+	options    []exporter.Option
+	ttl        time.Duration
+	maxTargets int
+
+	mu      sync.Mutex
+	targets map[string]*probeTarget
+}
+
+// ServeHTTP implements http.Handler.
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var stations []string
+	for _, v := range query["station"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				stations = append(stations, id)
+			}
+		}
+	}
+	location := query.Get("location")
+
+	radius := 10
+	if v := query.Get("radius"); v != "" {
+		var err error
+		if radius, err = strconv.Atoi(v); err != nil || radius < 1 || radius > exporter.MaxRadius {
+			http.Error(w, fmt.Sprintf("radius must be between 1 and %d km", exporter.MaxRadius), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(stations) == 0 && location == "" {
+		http.Error(w, "station or location parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if location != "" {
+		if err := exporter.ValidateLocation(location); err != nil {
+			http.Error(w, "invalid location: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The details of the error are only logged, as they might reveal
+	// internals of the exporter.
+	handler, err := p.target(stations, location, radius)
+	if errors.Is(err, errTooManyTargets) {
+		p.logger.Warn("cannot probe target", "err", err, "max_targets", p.maxTargets)
+		http.Error(w, "too many probe targets", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		p.logger.Error("cannot probe target", "err", err)
+		http.Error(w, "cannot create exporter for target", http.StatusBadGateway)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// target returns the handler serving the scrapes of the given target. It is
+// taken from the cache or created if there is none.
+func (p *prober) target(stations []string, location string, radius int) (http.Handler, error) {
+	sort.Strings(stations)
+	key := fmt.Sprintf("%s|%s|%d", strings.Join(stations, ","), location, radius)
+
+	now := time.Now()
+
+	p.mu.Lock()
+	p.evict(now)
+	if t, ok := p.targets[key]; ok {
+		t.lastUsed = now
+		p.mu.Unlock()
+		return t.handler, nil
+	}
+	full := len(p.targets) >= p.maxTargets
+	p.mu.Unlock()
+	if full {
+		return nil, errTooManyTargets
+	}
+
+	// Probes are served synchronously. Polls and refreshes in the background
+	// would spend API requests for every cached target, even if it isn't
+	// probed anymore.
+	options := append(p.options[:len(p.options):len(p.options)],
+		exporter.WithPollInterval(0),
+		exporter.WithMetadataRefresh(0),
+		exporter.WithLocationRefresh(0),
+		exporter.WithCacheDir(""),
+	)
+
+	// Create the exporter without holding the lock, as it performs API
+	// requests.
+	ctx, cancel := context.WithCancel(p.ctx)
+	var (
+		e   *exporter.Exporter
+		err error
+	)
+	switch {
+	case len(stations) > 0 && location != "":
+		e, err = exporter.NewForStationsAndLocation(ctx, p.logger, p.client, stations, location, radius, p.product, options...)
+	case len(stations) > 0:
+		e, err = exporter.NewForStations(ctx, p.logger, p.client, stations, p.product, options...)
+	default:
+		e, err = exporter.NewForLocation(ctx, p.logger, p.client, location, radius, p.product, options...)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("create exporter: %w", err)
+	}
+
+	t := &probeTarget{
+		handler:  metricsHandler(prometheus.Gatherers{}, e, p.logger),
+		cancel:   cancel,
+		lastUsed: now,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Another probe of the same target might have been faster.
+	if other, ok := p.targets[key]; ok {
+		cancel()
+		other.lastUsed = now
+		return other.handler, nil
+	}
+	// Concurrent probes of new targets might have filled up the cache.
+	if len(p.targets) >= p.maxTargets {
+		cancel()
+		return nil, errTooManyTargets
+	}
+	p.targets[key] = t
+
+	return t.handler, nil
+}
+
+// run discards the expired targets periodically until the context of the
+// prober is done, so they are discarded even if no further probes come in.
+func (p *prober) run() {
+	ticker := time.NewTicker(min(p.ttl, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			p.evict(now)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// evict discards the exporters that weren't used for longer than the TTL. It
+// must be called with p.mu held.
+func (p *prober) evict(now time.Time) {
+	for key, t := range p.targets {
+		if now.Sub(t.lastUsed) > p.ttl {
+			t.cancel()
+			delete(p.targets, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned for requests that are not sent because the API
+// failed repeatedly.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// breaker is a circuit breaker. After a threshold of consecutive failed
+// requests it opens and rejects all requests until the cooldown passed. It then
+// lets a single request through to test whether the API recovered, which
+// closes it on success and opens it again on failure.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	rejected  prometheus.Counter
+
+	mu       sync.Mutex
+	failures int
+	// openUntil is the time the circuit is open until. It is zero if the
+	// circuit is closed.
+	openUntil time.Time
+	// probing is set while the request testing the API is in flight.
+	probing bool
+}
+
+// allow reports whether a request may be sent and whether it tests the API.
+// It returns ErrCircuitOpen if it may not be sent.
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return false, nil
+	} else if time.Now().Before(b.openUntil) || b.probing {
+		b.rejected.Inc()
+		return false, ErrCircuitOpen
+	}
+
+	// Half-open: test the API with this request.
+	b.probing = true
+	return true, nil
+}
+
+// record records the outcome of a request that was allowed.
+func (b *breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// release releases a request that was allowed without recording its outcome,
+// so another request can test the API.
+func (b *breaker) release(probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+}
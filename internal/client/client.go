@@ -1,19 +1,338 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/alexruf/tankerkoenig-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/time/rate"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
 )
 
-// Client is a client for the Tankerkoenig API.
-type Client = tankerkoenig.Client
+// DefaultNamespace is the default namespace of the metrics collected by the
+// client.
+const DefaultNamespace = "tk"
+
+// DefaultMaxIdleConnsPerHost is the default maximum amount of idle connections
+// kept open to the API. It is higher than the default of net/http, so the
+// concurrent requests of a scrape reuse their connections on the next scrape.
+const DefaultMaxIdleConnsPerHost = 10
+
+// DefaultIdleConnTimeout is the default time an idle connection to the API is
+// kept open.
+const DefaultIdleConnTimeout = 90 * time.Second
+
+// ErrInvalidAPIKey is returned by CheckAPIKey if the API rejects the API key.
+var ErrInvalidAPIKey = errors.New("invalid or expired api key")
+
+// Client is a client for the Tankerkoenig API. It also collects metrics about
+// the requests made to the API.
+type Client struct {
+	*tankerkoenig.Client
+
+	namespace        string
+	nativeHistograms bool
+
+	retries     prometheus.Counter
+	rateLimited prometheus.Counter
+	limiterWait prometheus.Gauge
+	reachable   prometheus.Gauge
+	duration    *prometheus.HistogramVec
+	requests    *prometheus.CounterVec
+	bytes       *prometheus.CounterVec
+	circuitOpen prometheus.Counter
+
+	// The quota metrics are only exported once the API reported the quota.
+	quotaRemaining *prometheus.GaugeVec
+	quotaLimit     *prometheus.GaugeVec
+}
+
+// An Option modifies the configuration of a Client.
+type Option func(c *Client, t *transport)
+
+// WithRetries sets the maximum amount of times a request is retried when it
+// failed due to a transient error. Defaults to no retries.
+func WithRetries(n int) Option {
+	return func(_ *Client, t *transport) {
+		t.maxRetries = n
+	}
+}
+
+// WithRateLimit limits the rate of requests sent to the API to the given amount
+// per second. Requests exceeding the limit are delayed until they are permitted.
+// Defaults to no limit.
+func WithRateLimit(perSecond float64) Option {
+	return func(_ *Client, t *transport) {
+		t.limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+	}
+}
+
+// WithCircuitBreaker rejects all requests with ErrCircuitOpen for the given
+// cooldown after the given amount of consecutive requests failed due to a
+// transient error. A single request is then sent to test whether the API
+// recovered. Defaults to no circuit breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(_ *Client, t *transport) {
+		t.breaker = &breaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// WithBaseURL sets the base URL of the API, e.g. to route requests through a
+// caching proxy. Defaults to the official Tankerkoenig API.
+func WithBaseURL(baseURL *url.URL) Option {
+	return func(c *Client, _ *transport) {
+		u := *baseURL
+		// Relative API paths are resolved against the base URL, which drops its
+		// last path segment unless it ends with a slash.
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		c.BaseURL = &u
+	}
+}
+
+// WithProxy sends all requests through the proxy with the given URL. Defaults
+// to the proxy configured by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, if any.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(_ *Client, t *transport) {
+		t.next.Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// WithMaxIdleConnsPerHost sets the maximum amount of idle connections kept open
+// to the API for reuse by later requests. Defaults to
+// DefaultMaxIdleConnsPerHost.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(_ *Client, t *transport) {
+		t.next.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets the time an idle connection to the API is kept open
+// before it is closed. Zero keeps idle connections open indefinitely. Defaults
+// to DefaultIdleConnTimeout.
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(_ *Client, t *transport) {
+		t.next.IdleConnTimeout = timeout
+	}
+}
+
+// WithHTTP2 sets whether HTTP/2 is attempted for requests to the API, which
+// multiplexes concurrent requests over a single connection. Defaults to true.
+func WithHTTP2(enabled bool) Option {
+	return func(_ *Client, t *transport) {
+		t.next.ForceAttemptHTTP2 = enabled
+	}
+}
+
+// WithNamespace sets the namespace of the metrics collected by the client.
+// Defaults to DefaultNamespace.
+func WithNamespace(namespace string) Option {
+	return func(c *Client, _ *transport) {
+		c.namespace = namespace
+	}
+}
+
+// WithNativeHistograms sets whether the request duration is also collected as a
+// native histogram, which has a high resolution at a low cost. Scrapers that
+// don't support native histograms get the classic buckets. Defaults to false.
+func WithNativeHistograms(enabled bool) Option {
+	return func(c *Client, _ *transport) {
+		c.nativeHistograms = enabled
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client, _ *transport) {
+		c.UserAgent = userAgent
+	}
+}
 
 // New returns a new Tankerkoenig API client that uses the given API key for
-// authentication.
-func New(apiKey string) *Client {
-	return tankerkoenig.NewClient(apiKey, &http.Client{
-		Timeout: time.Second * 15,
+// authentication. Requests that take longer than the given timeout, including
+// all retries, are aborted. Every request is traced with the global tracer
+// provider, which doesn't record anything unless tracing is set up.
+func New(apiKey string, timeout time.Duration, options ...Option) *Client {
+	c := &Client{
+		namespace: DefaultNamespace,
+	}
+
+	next := http.DefaultTransport.(*http.Transport).Clone()
+	next.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	next.IdleConnTimeout = DefaultIdleConnTimeout
+	next.ForceAttemptHTTP2 = true
+
+	t := &transport{
+		next: next,
+	}
+
+	c.Client = tankerkoenig.NewClient(apiKey, &http.Client{
+		Transport: otelhttp.NewTransport(t, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return "tankerkoenig " + endpoint(req)
+		})),
+		Timeout: timeout,
+	})
+
+	for _, option := range options {
+		option(c, t)
+	}
+
+	c.retries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_retries_total",
+		Help:      "Total amount of retried Tankerkoenig API requests.",
+	})
+	c.rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "rate_limited_total",
+		Help:      "Total amount of Tankerkoenig API requests rejected due to rate limiting.",
+	})
+	c.limiterWait = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "rate_limiter_wait_seconds",
+		Help:      "Time the last Tankerkoenig API request waited for the client-side rate limiter.",
+	})
+	c.reachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: c.namespace,
+		Subsystem: "api",
+		Name:      "reachable",
+		Help:      "Did the last Tankerkoenig API request get a response? 1 for YES, 0 for NO.",
+	})
+	durationOpts := prometheus.HistogramOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of the Tankerkoenig API requests by endpoint. Every retry is observed on its own.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}
+	if c.nativeHistograms {
+		// Each bucket is at most 10% wider than the previous one. The amount of
+		// buckets is bounded, as the durations span a few orders of magnitude
+		// at most.
+		durationOpts.NativeHistogramBucketFactor = 1.1
+		durationOpts.NativeHistogramMaxBucketNumber = 100
+		durationOpts.NativeHistogramMinResetDuration = time.Hour
+	}
+	c.duration = prometheus.NewHistogramVec(durationOpts, []string{"endpoint"})
+	c.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_requests_total",
+		Help:      "Total amount of Tankerkoenig API requests by endpoint and status code. Requests without a response have the status code \"error\".",
+	}, []string{"endpoint", "status_code"})
+	c.bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_response_bytes_total",
+		Help:      "Total amount of bytes read from Tankerkoenig API responses by endpoint, after decompression. Responses of retried requests are included.",
+	}, []string{"endpoint"})
+	c.circuitOpen = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "circuit_open_total",
+		Help:      "Total amount of Tankerkoenig API requests rejected because the API failed repeatedly.",
 	})
+
+	c.quotaRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_quota_remaining",
+		Help:      "Remaining Tankerkoenig API requests of the quota, as reported by the last response that included it.",
+	}, nil)
+	c.quotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: c.namespace,
+		Subsystem: "exporter",
+		Name:      "api_quota_limit",
+		Help:      "Tankerkoenig API requests allowed by the quota, as reported by the last response that included it.",
+	}, nil)
+
+	t.retries = c.retries
+	t.rateLimited = c.rateLimited
+	t.limiterWait = c.limiterWait
+	t.reachable = c.reachable
+	t.duration = c.duration
+	t.requests = c.requests
+	t.bytes = c.bytes
+	t.quotaRemaining = c.quotaRemaining
+	t.quotaLimit = c.quotaLimit
+	if t.breaker != nil {
+		t.breaker.rejected = c.circuitOpen
+	}
+
+	return c
+}
+
+// CheckAPIKey makes a small authenticated request to verify that the API
+// accepts the API key. It returns ErrInvalidAPIKey if it doesn't.
+func (c *Client) CheckAPIKey(ctx context.Context) error {
+	query := url.Values{}
+	query.Add("ids", `["00000000-0000-0000-0000-000000000000"]`)
+	query.Add("apikey", c.APIKey)
+
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, "json/prices.php", query, nil)
+	if err != nil {
+		return err
+	}
+
+	var root struct {
+		Ok      bool   `json:"ok"`
+		Message string `json:"message"`
+	}
+	if _, err = c.Do(req, &root); err != nil {
+		var errResp *tankerkoenig.ErrorResponse
+		if errors.As(err, &errResp) {
+			if code := errResp.Response.StatusCode; code == http.StatusUnauthorized || code == http.StatusForbidden {
+				return ErrInvalidAPIKey
+			}
+		}
+		return err
+	}
+
+	// The API also reports a rejected API key with a successful status code.
+	if !root.Ok && strings.Contains(strings.ToLower(root.Message), "apikey") {
+		return ErrInvalidAPIKey
+	}
+
+	return nil
+}
+
+// Describe all the metrics collected by the client.
+// Implements prometheus.Collector.
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	c.retries.Describe(ch)
+	c.rateLimited.Describe(ch)
+	c.limiterWait.Describe(ch)
+	c.reachable.Describe(ch)
+	c.duration.Describe(ch)
+	c.requests.Describe(ch)
+	c.bytes.Describe(ch)
+	c.circuitOpen.Describe(ch)
+	c.quotaRemaining.Describe(ch)
+	c.quotaLimit.Describe(ch)
+}
+
+// Collect the metrics of the client.
+// Implements prometheus.Collector.
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	c.retries.Collect(ch)
+	c.rateLimited.Collect(ch)
+	c.limiterWait.Collect(ch)
+	c.reachable.Collect(ch)
+	c.duration.Collect(ch)
+	c.requests.Collect(ch)
+	c.bytes.Collect(ch)
+	c.circuitOpen.Collect(ch)
+	c.quotaRemaining.Collect(ch)
+	c.quotaLimit.Collect(ch)
 }
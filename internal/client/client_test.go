@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNew_ReusesConnections(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		_ = json.Unmarshal([]byte(r.URL.Query().Get("ids")), &ids)
+		prices := make(map[string]any, len(ids))
+		for _, id := range ids {
+			prices[id] = map[string]any{"status": "open", "e5": 1.789}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "prices": prices})
+	}))
+	var conns atomic.Int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New("test-key", time.Second*5, WithBaseURL(baseURL))
+
+	// Retrieve the prices in batches, like the exporter does on every scrape.
+	const (
+		scrapes     = 10
+		concurrency = 4
+	)
+	batches := [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}, {"g", "h"}}
+	for scrape := 0; scrape < scrapes; scrape++ {
+		var wg sync.WaitGroup
+		for _, batch := range batches[:concurrency] {
+			wg.Add(1)
+			go func(batch []string) {
+				defer wg.Done()
+				if _, _, err := c.Prices.GetWithContext(context.Background(), batch...); err != nil {
+					t.Errorf("GetWithContext() = %v", err)
+				}
+			}(batch)
+		}
+		wg.Wait()
+	}
+
+	if n := conns.Load(); n > concurrency {
+		t.Errorf("%d requests opened %d connections, want at most %d", scrapes*concurrency, n, concurrency)
+	}
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Headers the quota of the API key might be reported in, by precedence. The API
+// doesn't document any, so the common conventions are recognized.
+var (
+	quotaRemainingHeaders = []string{"RateLimit-Remaining", "X-RateLimit-Remaining", "X-Quota-Remaining"}
+	quotaLimitHeaders     = []string{"RateLimit-Limit", "X-RateLimit-Limit", "X-Quota-Limit"}
+)
+
+// observeQuota updates the quota metrics from the quota headers of the given
+// response. Metrics whose header is missing keep their value.
+func (t *transport) observeQuota(h http.Header) {
+	if v, ok := quotaHeader(h, quotaRemainingHeaders); ok {
+		t.quotaRemaining.WithLabelValues().Set(v)
+	}
+	if v, ok := quotaHeader(h, quotaLimitHeaders); ok {
+		t.quotaLimit.WithLabelValues().Set(v)
+	}
+}
+
+// quotaHeader returns the value of the first of the given headers that is set
+// to a number. Only the first value of a list like "100, 100;w=60" is used.
+func quotaHeader(h http.Header, names []string) (float64, bool) {
+	for _, name := range names {
+		v := h.Get(name)
+		if i := strings.IndexAny(v, ",;"); i >= 0 {
+			v = v[:i]
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 {
+			return f, true
+		}
+	}
+	return 0, false
+}
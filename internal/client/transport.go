@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+const (
+	minBackoff = time.Millisecond * 500
+	maxBackoff = time.Second * 10
+)
+
+// transport is the http.RoundTripper used by the Client. It retries idempotent
+// requests that failed due to a transient error with exponential backoff. Rate
+// limited requests are retried once after the duration the API asks for. If a
+// limiter is set, every request waits for it before being sent. If a breaker
+// is set, requests are rejected while the API keeps failing.
+type transport struct {
+	next *http.Transport
+
+	breaker *breaker
+
+	limiter     *rate.Limiter
+	limiterWait prometheus.Gauge
+
+	maxRetries  int
+	retries     prometheus.Counter
+	rateLimited prometheus.Counter
+	reachable   prometheus.Gauge
+	duration    *prometheus.HistogramVec
+	requests    *prometheus.CounterVec
+	bytes       *prometheus.CounterVec
+
+	quotaRemaining *prometheus.GaugeVec
+	quotaLimit     *prometheus.GaugeVec
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.breaker == nil {
+		return t.roundTrip(req)
+	}
+
+	probe, err := t.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(req)
+	// Requests canceled on purpose don't tell anything about the API, unlike
+	// ones that timed out.
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		t.breaker.release(probe)
+	} else {
+		failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		t.breaker.record(probe, failed)
+	}
+	return resp, err
+}
+
+// roundTrip sends the request, retrying it if needed.
+func (t *transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.send(req)
+	}
+
+	var (
+		attempts          int
+		honoredRetryAfter bool
+	)
+	for {
+		if err := t.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.send(req)
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			t.rateLimited.Inc()
+		}
+
+		var wait time.Duration
+		if d, ok := retryAfter(resp); ok && !honoredRetryAfter {
+			// Waiting is pointless if the request times out before it can be
+			// retried.
+			if deadline, hasDeadline := req.Context().Deadline(); hasDeadline && time.Now().Add(d).After(deadline) {
+				return resp, err
+			}
+			honoredRetryAfter = true
+			wait = d
+		} else if attempts < t.maxRetries && isTransient(req.Context(), resp, err) {
+			wait = t.backoff(attempts)
+			attempts++
+		} else {
+			return resp, err
+		}
+
+		// Discard the response of the failed attempt so the underlying
+		// connection can be reused.
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		t.retries.Inc()
+	}
+}
+
+// send sends the request, measures its duration, counts it by its status code
+// and records whether the API responded at all. Requests without a response are
+// counted with the status code "error". Any response, even an error status,
+// counts as reachable. Requests aborted by their context don't tell anything
+// about the API. The quota reported by a response, if any, is recorded as well
+// and the bytes read from the response body are counted.
+func (t *transport) send(req *http.Request) (*http.Response, error) {
+	name := endpoint(req)
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(attribute.String("tankerkoenig.endpoint", name))
+	if ids := req.URL.Query().Get("ids"); ids != "" {
+		var stations []string
+		if json.Unmarshal([]byte(ids), &stations) == nil {
+			span.SetAttributes(attribute.Int("tankerkoenig.stations", len(stations)))
+		}
+	}
+
+	begun := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.duration.WithLabelValues(name).Observe(time.Since(begun).Seconds())
+
+	// Point out a failing proxy, as the error would otherwise be mistaken for
+	// an unreachable API.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		err = fmt.Errorf("cannot connect to proxy: %w", err)
+	}
+
+	if err == nil {
+		t.requests.WithLabelValues(name, strconv.Itoa(resp.StatusCode)).Inc()
+		t.reachable.Set(1)
+		t.observeQuota(resp.Header)
+		resp.Body = &countingBody{ReadCloser: resp.Body, bytes: t.bytes.WithLabelValues(name)}
+	} else {
+		t.requests.WithLabelValues(name, "error").Inc()
+		if req.Context().Err() == nil {
+			t.reachable.Set(0)
+		}
+	}
+	return resp, err
+}
+
+// countingBody is a response body that counts the bytes read from it.
+type countingBody struct {
+	io.ReadCloser
+	bytes prometheus.Counter
+}
+
+// Read implements io.Reader.
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes.Add(float64(n))
+	return n, err
+}
+
+// endpoint returns the name of the API endpoint the request is sent to, like
+// "prices" for json/prices.php.
+func endpoint(req *http.Request) string {
+	switch path.Base(req.URL.Path) {
+	case "detail.php":
+		return "detail"
+	case "list.php":
+		return "list"
+	case "prices.php":
+		return "prices"
+	default:
+		return "other"
+	}
+}
+
+// wait blocks until the limiter permits another request or the context is
+// canceled.
+func (t *transport) wait(ctx context.Context) error {
+	if t.limiter == nil {
+		return nil
+	}
+
+	defer func(begun time.Time) {
+		t.limiterWait.Set(time.Since(begun).Seconds())
+	}(time.Now())
+
+	return t.limiter.Wait(ctx)
+}
+
+// backoff returns the duration to wait before the next attempt. It grows
+// exponentially with the amount of attempts made, half of it being random
+// jitter.
+func (t *transport) backoff(attempt int) time.Duration {
+	d := minBackoff << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+
+	t.randMu.Lock()
+	defer t.randMu.Unlock()
+	if t.rand == nil {
+		t.rand = rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // Jitter doesn't need to be secure.
+	}
+
+	return d/2 + time.Duration(t.rand.Int63n(int64(d/2)))
+}
+
+// isTransient reports whether a request failed due to a transient error and is
+// worth retrying. Those are network errors, server errors and rate limits.
+func isTransient(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// Don't retry if the request was canceled on purpose.
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter returns the duration a rate limited response asks the client to
+// wait before retrying the request. The Retry-After header either holds the
+// amount of seconds to wait or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	} else if date, err := http.ParseTime(v); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sleep pauses for the given duration or until the context is canceled,
+// whichever happens first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+// detailsCacheFile is the name of the file the station details are cached in.
+const detailsCacheFile = "stations.json"
+
+// detailsCacheMu serializes the access to the details cache, which is shared by
+// all exporters using the same cache directory.
+var detailsCacheMu sync.Mutex
+
+// initialStationDetails retrieves the details of the stations with the given
+// IDs on startup. Transient failures are retried, so a blip of the API doesn't
+// prevent the exporter from starting. If a cache directory is set, the details
+// are taken from the details cache and only stations missing from it are
+// retrieved. The cached details are revalidated in the background once the
+// exporter started.
+func (e *Exporter) initialStationDetails(ctx context.Context, ids []string) (map[string]tankerkoenig.Station, error) {
+	if e.cacheDir == "" {
+		return e.retrieveStationDetails(ctx, ids, startupAttempts)
+	}
+
+	cached := e.readDetailsCache()
+	stations := make(map[string]tankerkoenig.Station, len(ids))
+	var missing []string
+	for _, id := range ids {
+		if station, ok := cached[id]; ok {
+			stations[id] = station
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(stations) > 0 {
+		e.logger.Info("using cached station details", "cached", len(stations), "total", len(ids))
+		e.revalidate = true
+	}
+
+	if len(missing) > 0 {
+		retrieved, err := e.retrieveStationDetails(ctx, missing, startupAttempts)
+		if err != nil {
+			return nil, err
+		}
+		for id, station := range retrieved {
+			stations[id] = station
+		}
+	}
+
+	return stations, nil
+}
+
+// readDetailsCache returns the station details from the details cache. A
+// missing or unreadable cache is treated as empty.
+func (e *Exporter) readDetailsCache() map[string]tankerkoenig.Station {
+	detailsCacheMu.Lock()
+	defer detailsCacheMu.Unlock()
+
+	stations, err := readDetailsCacheFile(filepath.Join(e.cacheDir, detailsCacheFile))
+	if err != nil {
+		e.logger.Warn("cannot read station details cache", "err", err)
+	}
+	return stations
+}
+
+// writeDetailsCache adds the given station details to the details cache,
+// replacing the cached details of the same stations.
+func (e *Exporter) writeDetailsCache(stations map[string]tankerkoenig.Station) {
+	detailsCacheMu.Lock()
+	defer detailsCacheMu.Unlock()
+
+	path := filepath.Join(e.cacheDir, detailsCacheFile)
+	cached, err := readDetailsCacheFile(path)
+	if err != nil {
+		e.logger.Warn("cannot read station details cache, replacing it", "err", err)
+	}
+	if cached == nil {
+		cached = make(map[string]tankerkoenig.Station, len(stations))
+	}
+	for id, station := range stations {
+		cached[id] = station
+	}
+
+	if err := writeDetailsCacheFile(path, cached); err != nil {
+		e.logger.Warn("cannot write station details cache", "err", err)
+	}
+}
+
+// readDetailsCacheFile reads the station details from the cache file at the
+// given path. A missing file is not an error.
+func readDetailsCacheFile(path string) (map[string]tankerkoenig.Station, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stations map[string]tankerkoenig.Station
+	if err := json.Unmarshal(b, &stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+// writeDetailsCacheFile writes the station details to the cache file at the
+// given path. The file is replaced atomically, so it is never left partially
+// written.
+func writeDetailsCacheFile(path string, stations map[string]tankerkoenig.Station) error {
+	b, err := json.Marshal(stations)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), detailsCacheFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+func TestExporter_writeDetailsCache(t *testing.T) {
+	dir := t.TempDir()
+	e := newExporter(context.Background(), testLogger(), nil, "all", WithCacheDir(dir))
+
+	e.writeDetailsCache(map[string]tankerkoenig.Station{
+		"a": {Name: "Station A"},
+		"b": {Name: "Station B"},
+	})
+	// Details of further stations are merged into the cache and the details
+	// of stations cached already are replaced.
+	e.writeDetailsCache(map[string]tankerkoenig.Station{
+		"b": {Name: "Station B2"},
+		"c": {Name: "Station C"},
+	})
+
+	cached := e.readDetailsCache()
+	want := map[string]string{"a": "Station A", "b": "Station B2", "c": "Station C"}
+	if len(cached) != len(want) {
+		t.Errorf("cached %d stations, want %d", len(cached), len(want))
+	}
+	for id, name := range want {
+		if got := cached[id].Name; got != name {
+			t.Errorf("cached name of station %q = %q, want %q", id, got, name)
+		}
+	}
+
+	// The cache is written atomically, so no temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != detailsCacheFile {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("cache directory contains %v, want only %s", names, detailsCacheFile)
+	}
+}
+
+func TestExporter_writeDetailsCache_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, detailsCacheFile)
+	if err := os.WriteFile(path, []byte(`{"a":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := newExporter(context.Background(), testLogger(), nil, "all", WithCacheDir(dir))
+
+	// An unreadable cache is treated as empty and replaced.
+	if cached := e.readDetailsCache(); len(cached) != 0 {
+		t.Errorf("read %d stations from corrupt cache, want none", len(cached))
+	}
+	e.writeDetailsCache(map[string]tankerkoenig.Station{"b": {Name: "Station B"}})
+	cached, err := readDetailsCacheFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 || cached["b"].Name != "Station B" {
+		t.Errorf("cache = %v, want only station b", cached)
+	}
+}
+
+func TestReadDetailsCacheFile_Missing(t *testing.T) {
+	cached, err := readDetailsCacheFile(filepath.Join(t.TempDir(), detailsCacheFile))
+	if err != nil || cached != nil {
+		t.Errorf("readDetailsCacheFile() = %v, %v, want nil, nil", cached, err)
+	}
+}
@@ -1,88 +1,759 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/alexruf/tankerkoenig-go"
 	"github.com/mmcloughlin/geohash"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 
 	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/client"
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
 )
 
-const namespace = "tk"
+// DefaultNamespace is the default namespace of the exported metrics.
+const DefaultNamespace = "tk"
+
+// MaxRadius is the maximum search radius in kilometers the Tankerkoenig API
+// accepts.
+const MaxRadius = 25
+
+// MaxBatchSize is the maximum amount of stations the Tankerkoenig API accepts
+// in a single prices request.
+const MaxBatchSize = 10
+
+// startupAttempts is the amount of attempts made to retrieve the details of a
+// station on startup, so a transient API failure doesn't prevent the exporter
+// from starting.
+const startupAttempts = 4
+
+// startupBackoff is the backoff before the first retry of a station details
+// retrieval on startup. It doubles with every further retry.
+const startupBackoff = time.Second
+
+// PriceLabels are the labels the price metric can be labeled by. The id and
+// product labels are required to tell the prices apart.
+var PriceLabels = []string{"id", "product", "name", "city", "brand"}
 
 var caser = cases.Title(language.German)
 
+// tracer traces scrapes with the global tracer provider.
+var tracer = otel.Tracer("github.com/lukasmalkmus/tankerkoenig_exporter/internal/exporter")
+
+// acronyms are words in station names and brands that are kept upper case when
+// normalizing their case, as they are usually written that way.
+var acronyms = map[string]struct{}{
+	"AVIA": {}, "BFT": {}, "BP": {}, "HEM": {}, "JET": {}, "OMV": {}, "ORLEN": {},
+}
+
+// products are the fuel products the Tankerkoenig API reports prices for.
+var products = []string{"diesel", "e5", "e10"}
+
+// spreadPairs are the pairs of products the price spread is exported for. The
+// spread is the price of the first product minus the price of the second one.
+var spreadPairs = [][2]string{{"e5", "e10"}, {"e5", "diesel"}, {"e10", "diesel"}}
+
+// statuses are the statuses the Tankerkoenig API reports for stations.
+var statuses = []string{"open", "closed", "no prices"}
+
+// priceKey identifies the price of a stations product.
+type priceKey struct {
+	id, product string
+}
+
+// priceState is the state of a stations product price, tracked across scrapes.
+type priceState struct {
+	price   float64
+	changes uint64
+	// since is the time the price was first observed at its current value.
+	since time.Time
+	// traceID identifies the trace of the scrape or poll that retrieved the
+	// price first. Only valid if tracing is set up.
+	traceID trace.TraceID
+	// recent are the prices of the most recent retrievals, kept in a ring
+	// buffer the size of the baseline window. next is the index of the oldest
+	// one, which is overwritten next.
+	recent []float64
+	next   int
+}
+
+// sample adds the given price to the recent prices, dropping the oldest one if
+// the window is full.
+func (s *priceState) sample(price float64, window int) {
+	if len(s.recent) < window {
+		s.recent = append(s.recent, price)
+		return
+	}
+	s.recent[s.next] = price
+	s.next = (s.next + 1) % window
+}
+
+// recentMin returns the minimum of the recent prices.
+func (s priceState) recentMin() float64 {
+	m := math.Inf(1)
+	for _, price := range s.recent {
+		m = math.Min(m, price)
+	}
+	return m
+}
+
+// cheapestPrice is the cheapest price of a product around the search location.
+type cheapestPrice struct {
+	id          string
+	price, dist float64
+}
+
 // Exporter collects stats from the Tankerkoenig API and exports them using the
 // prometheus client library.
 type Exporter struct {
-	logger *log.Logger
+	// ctx bounds the lifetime of the exporter. All API requests are made with
+	// it, so they are aborted once it is canceled.
+	ctx    context.Context
+	logger *slog.Logger
 
 	mutex    sync.RWMutex
-	client   *tankerkoenig.Client
+	client   *client.Client
 	stations map[string]tankerkoenig.Station
+	product  string
+
+	// priceStates holds the state of every price observed so far. They are
+	// updated once per retrieval of the prices, not on every collect.
+	priceStates map[priceKey]priceState
+
+	// absences counts the consecutive scrapes a station was missing from the
+	// prices response, by station ID.
+	absences map[string]int
+
+	// ready is set once the initial station details were retrieved and
+	// lastScrapeOK reports whether the most recent scrape or poll succeeded.
+	// They are atomic, so Ready doesn't wait for a scrape in progress.
+	ready        atomic.Bool
+	lastScrapeOK atomic.Bool
+
+	// search is the location search the stations originate from. Only set if
+	// the exporter was created for a location.
+	search *search
+	// pinned are the IDs of the stations monitored in addition to the ones
+	// found by the location search. Only set if the exporter was created for
+	// both stations and a location or stations were set for a location later.
+	pinned []string
+	// excluded are the IDs of the stations left out of a location search.
+	excluded map[string]struct{}
+	// requireStations fails the creation of an exporter for a location if no
+	// stations are found around it.
+	requireStations bool
+	// brands are the lower case brands of the monitored stations. Stations of
+	// other brands are left out. If empty, all brands are monitored.
+	brands map[string]struct{}
+
+	detailConcurrency int
+	batchSize         int
+	maxConcurrency    int
+	scrapeTimeout     time.Duration
+	metadataRefresh   time.Duration
+	locationRefresh   time.Duration
+	pollInterval      time.Duration
+	priceUnit         string
+	pricePrecision    int
+	namespace         string
+	details           bool
+	priceLabels       []string
+	sort              string
+	rawNames          bool
+	dropMissingAfter  int
+	baselineWindow    int
+	names             map[string]string
+	cacheDir          string
+
+	// revalidate is set if the initial station details were taken from the
+	// details cache and must be retrieved again once the exporter started.
+	revalidate bool
+
+	// cachedPrices are the prices retrieved by the last successful scrape or
+	// poll at cachedAt. Collects only serve them if the prices are polled.
+	cachedPrices  map[string]tankerkoenig.Price
+	cachedAt      time.Time
+	cachedTraceID trace.TraceID
+	// snapshot is the snapshot of the cached prices served by Prices.
+	snapshot atomic.Pointer[pricesSnapshot]
+
+	// license is the license of the prices most recently reported by the API.
+	license string
 
 	// Basic exporter metrics.
-	up, scrapeDuration          prometheus.Gauge
-	totalScrapes, failedScrapes prometheus.Counter
+	up, scrapeDuration             prometheus.Gauge
+	totalScrapes, failedScrapes    prometheus.Counter
+	failedBatches                  prometheus.Counter
+	consecutiveFailures            prometheus.Gauge
+	priceBatches, priceBatchErrors prometheus.Gauge
+	cacheAge                       prometheus.Gauge
+	monitoredStations              prometheus.Gauge
+	lastSuccess                    prometheus.Gauge
+
+	// inProgress is the amount of collects in progress. It is exported as a
+	// snapshot, as gauges are only read after the collect returned.
+	inProgress     atomic.Int64
+	inProgressDesc *prometheus.Desc
 
 	// Tankerkoenig metrics.
-	priceDesc   *prometheus.Desc
-	openDesc    *prometheus.Desc
-	detailsDesc *prometheus.Desc
+	priceDesc    *prometheus.Desc
+	spreadDesc   *prometheus.Desc
+	openDesc     *prometheus.Desc
+	detailsDesc  *prometheus.Desc
+	distanceDesc *prometheus.Desc
+	isOpenDesc   *prometheus.Desc
+
+	wholeDayOpenDesc  *prometheus.Desc
+	scheduledOpenDesc *prometheus.Desc
+	priceChangesDesc  *prometheus.Desc
+	priceUpdatedDesc  *prometheus.Desc
+	baselineDesc      *prometheus.Desc
+	scrapeErrorDesc   *prometheus.Desc
+	statusDesc        *prometheus.Desc
+
+	cheapestPriceDesc   *prometheus.Desc
+	cheapestStationDesc *prometheus.Desc
+
+	licenseDesc         *prometheus.Desc
+	configInfoDesc      *prometheus.Desc
+	stationsByBrandDesc *prometheus.Desc
 }
 
-// NewForStations returns a new, initialized Tankerkoenig API exporter for the
-// given stations.
-func NewForStations(logger *log.Logger, apiClient *client.Client, apiStations []string) (*Exporter, error) {
-	e := newExporter(logger, apiClient)
+// An Option modifies the configuration of an Exporter.
+type Option func(*Exporter)
+
+// WithDetailConcurrency sets the maximum amount of station details retrieved
+// concurrently when creating the exporter. Defaults to 4.
+func WithDetailConcurrency(n int) Option {
+	return func(e *Exporter) {
+		e.detailConcurrency = n
+	}
+}
+
+// WithMaxConcurrency sets the maximum amount of batches of prices retrieved
+// concurrently. Defaults to 4.
+func WithMaxConcurrency(n int) Option {
+	return func(e *Exporter) {
+		e.maxConcurrency = n
+	}
+}
+
+// WithBatchSize sets the maximum amount of stations whose prices are retrieved
+// with a single request. Smaller batches limit the prices lost to a failed
+// request. Must be between 1 and MaxBatchSize, which is the default.
+func WithBatchSize(n int) Option {
+	return func(e *Exporter) {
+		e.batchSize = n
+	}
+}
+
+// WithScrapeTimeout bounds the retrieval of the prices of all batches. If it
+// takes longer, the outstanding requests are canceled and the scrape fails.
+// Defaults to no timeout.
+func WithScrapeTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) {
+		e.scrapeTimeout = timeout
+	}
+}
+
+// WithMetadataRefresh periodically refreshes the metadata of the monitored
+// stations, like their name, brand and address, in the given interval. Defaults
+// to no refresh.
+func WithMetadataRefresh(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.metadataRefresh = interval
+	}
+}
+
+// WithLocationRefresh periodically searches for stations around the location
+// again in the given interval, picking up stations that entered or left the
+// search radius. Only applies to exporters created for a location. Defaults to
+// no refresh.
+func WithLocationRefresh(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.locationRefresh = interval
+	}
+}
+
+// WithPollInterval retrieves the prices in the background in the given interval
+// instead of on every collect. Collects are served from the prices retrieved by
+// the last successful poll. Defaults to no polling.
+func WithPollInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.pollInterval = interval
+	}
+}
+
+// WithPriceUnit sets the unit prices are exported in, which must be one of
+// "euro" or "cent". Defaults to "euro".
+func WithPriceUnit(unit string) Option {
+	return func(e *Exporter) {
+		e.priceUnit = unit
+	}
+}
+
+// WithPricePrecision sets the amount of decimals prices in euro are rounded to.
+// Defaults to 3, a tenth of a cent.
+func WithPricePrecision(decimals int) Option {
+	return func(e *Exporter) {
+		e.pricePrecision = decimals
+	}
+}
+
+// WithNamespace sets the namespace of the exported metrics, e.g. to avoid
+// collisions with the metrics of another exporter. Defaults to
+// DefaultNamespace.
+func WithNamespace(namespace string) Option {
+	return func(e *Exporter) {
+		e.namespace = namespace
+	}
+}
+
+// WithDetails sets whether the details metric is exported. Disabling it avoids
+// the cardinality of its labels if the details are known elsewhere. Defaults to
+// true.
+func WithDetails(enabled bool) Option {
+	return func(e *Exporter) {
+		e.details = enabled
+	}
+}
+
+// WithPriceLabels sets the labels of the price metric, which must be a subset of
+// PriceLabels including id and product. Defaults to id and product.
+func WithPriceLabels(labels ...string) Option {
+	return func(e *Exporter) {
+		e.priceLabels = labels
+	}
+}
+
+// WithSort sets the order the API sorts the stations found around the location
+// by, which must be one of "dist" or "price". Sorting by price requires a
+// product other than "all". Defaults to "dist".
+func WithSort(sort string) Option {
+	return func(e *Exporter) {
+		e.sort = sort
+	}
+}
+
+// WithRawNames sets whether station names and brands are exported as reported
+// by the API. Otherwise their case is normalized, as the API often reports them
+// all upper case. Defaults to false.
+func WithRawNames(raw bool) Option {
+	return func(e *Exporter) {
+		e.rawNames = raw
+	}
+}
+
+// WithBaselineWindow sets the amount of recent prices of each station product
+// the current price is compared to, e.g. 24 for the prices of the last 24
+// scrapes. Zero doesn't export the comparison. Defaults to zero.
+func WithBaselineWindow(samples int) Option {
+	return func(e *Exporter) {
+		e.baselineWindow = samples
+	}
+}
+
+// WithNames sets the names of the stations with the given IDs, overriding the
+// names reported by the API, e.g. to give stations names like "Home". Stations
+// without a name keep the one reported by the API.
+func WithNames(names map[string]string) Option {
+	return func(e *Exporter) {
+		e.names = names
+	}
+}
+
+// WithDropMissing stops monitoring stations that were missing from the prices
+// response of the given amount of consecutive scrapes, e.g. because they were
+// decommissioned. Their series then go stale instead of being exported with
+// outdated values. Zero keeps monitoring them. Defaults to zero.
+func WithDropMissing(scrapes int) Option {
+	return func(e *Exporter) {
+		e.dropMissingAfter = scrapes
+	}
+}
+
+// WithCacheDir caches the station details in the given directory, so they
+// survive restarts. Cached details are used right away on startup and are
+// revalidated in the background. Defaults to no caching.
+func WithCacheDir(dir string) Option {
+	return func(e *Exporter) {
+		e.cacheDir = dir
+	}
+}
+
+// WithExcludedStations leaves the stations with the given IDs out of the
+// stations found around the location. Only applies to exporters created for a
+// location.
+func WithExcludedStations(ids ...string) Option {
+	return func(e *Exporter) {
+		for _, id := range ids {
+			e.excluded[id] = struct{}{}
+		}
+	}
+}
+
+// WithRequireStations sets whether creating an exporter for a location fails if
+// no stations are found around it, or are left after excluding stations and
+// filtering by brand. Only applies to exporters created for a location.
+// Defaults to true.
+func WithRequireStations(required bool) Option {
+	return func(e *Exporter) {
+		e.requireStations = required
+	}
+}
+
+// WithBrands only monitors stations of the given brands, which are matched case
+// insensitively. Defaults to all brands.
+func WithBrands(brands ...string) Option {
+	return func(e *Exporter) {
+		for _, brand := range brands {
+			e.brands[strings.ToLower(strings.TrimSpace(brand))] = struct{}{}
+		}
+	}
+}
 
-	e.stations = make(map[string]tankerkoenig.Station, len(apiStations))
+// NewForStations returns a new, initialized Tankerkoenig API exporter for the
+// given stations. Only prices for the given product are exported, which must be
+// one of "e5", "e10", "diesel" or "all". The given context bounds all API
+// requests made by the exporter.
+func NewForStations(ctx context.Context, logger *slog.Logger, apiClient *client.Client, apiStations []string, product string, options ...Option) (*Exporter, error) {
+	e := newExporter(ctx, logger, apiClient, product, options...)
+	apiStations = e.uniqueStations(apiStations)
 
 	// Retrieve initial station details to validate integrity of user provided
 	// station IDs.
-	for _, id := range apiStations {
-		station, _, err := apiClient.Station.Detail(id)
-		if err != nil {
-			return nil, fmt.Errorf("could not retrieve station details for station %s: %w", id, err)
-		} else if station.Id == "" {
-			return nil, fmt.Errorf("station %q was not found", id)
-		}
-		e.stations[id] = station
+	stations, err := e.initialStationDetails(ctx, apiStations)
+	if err != nil {
+		return nil, err
+	}
+	if n := e.filterBrands(stations); len(e.brands) > 0 {
+		e.logger.Info("filtered stations by brand", "matched", len(stations), "total", n+len(stations))
 	}
+	e.setStations(stations)
+	e.ready.Store(true)
+
+	e.start()
 
 	return e, nil
 }
 
 // NewForLocation returns a new, initialized Tankerkoenig API exporter for the
-// stations that are in the given radius around the given location.
-func NewForLocation(logger *log.Logger, apiClient *client.Client, location string, radius int) (*Exporter, error) {
-	e := newExporter(logger, apiClient)
+// stations that are in the given radius around the given location. Only
+// stations offering the given product are considered, which must be one of
+// "e5", "e10", "diesel" or "all". The given context bounds all API requests
+// made by the exporter.
+func NewForLocation(ctx context.Context, logger *slog.Logger, apiClient *client.Client, location string, radius int, product string, options ...Option) (*Exporter, error) {
+	lat, lng, err := decodeLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	return NewForCoordinates(ctx, logger, apiClient, lat, lng, radius, product, options...)
+}
+
+// NewForCoordinates is like NewForLocation but the location is given by its
+// latitude and longitude.
+func NewForCoordinates(ctx context.Context, logger *slog.Logger, apiClient *client.Client, lat, lng float64, radius int, product string, options ...Option) (*Exporter, error) {
+	if radius < 1 || radius > MaxRadius {
+		return nil, fmt.Errorf("radius must be between 1 and %d km", MaxRadius)
+	}
 
-	lat, lng := geohash.Decode(location)
+	e := newExporter(ctx, logger, apiClient, product, options...)
 
-	stations, _, err := apiClient.Station.List(lat, lng, radius)
+	e.search = &search{lat: lat, lng: lng, radius: radius}
+	e.logger.Info("searching for stations around location", "lat", lat, "lng", lng, "radius_km", radius)
+
+	stations, err := e.searchStations(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not list stations: %w", err)
+		return nil, err
+	}
+	if len(stations) == 0 && e.requireStations {
+		return nil, fmt.Errorf("no stations found within %d km of the location", radius)
+	}
+	if n := e.excludeStations(stations); n > 0 {
+		e.logger.Info("excluded stations around location", "excluded", n, "total", n+len(stations))
+	}
+	if n := e.filterBrands(stations); len(e.brands) > 0 {
+		e.logger.Info("filtered stations by brand", "matched", len(stations), "total", n+len(stations))
+	}
+	if len(stations) == 0 && e.requireStations {
+		return nil, errors.New("no stations left around the location after excluding stations and filtering by brand")
+	}
+	e.setStations(stations)
+	e.ready.Store(true)
+
+	e.start()
+
+	return e, nil
+}
+
+// NewForStationsAndLocation returns a new, initialized Tankerkoenig API
+// exporter for the given stations in addition to the stations that are in the
+// given radius around the given location. Stations matched by both are only
+// monitored once. Only prices for the given product are exported, which must be
+// one of "e5", "e10", "diesel" or "all". The given context bounds all API
+// requests made by the exporter.
+func NewForStationsAndLocation(ctx context.Context, logger *slog.Logger, apiClient *client.Client, apiStations []string, location string, radius int, product string, options ...Option) (*Exporter, error) {
+	lat, lng, err := decodeLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	return NewForStationsAndCoordinates(ctx, logger, apiClient, apiStations, lat, lng, radius, product, options...)
+}
+
+// NewForStationsAndCoordinates is like NewForStationsAndLocation but the
+// location is given by its latitude and longitude.
+func NewForStationsAndCoordinates(ctx context.Context, logger *slog.Logger, apiClient *client.Client, apiStations []string, lat, lng float64, radius int, product string, options ...Option) (*Exporter, error) {
+	if radius < 1 || radius > MaxRadius {
+		return nil, fmt.Errorf("radius must be between 1 and %d km", MaxRadius)
 	}
 
-	e.stations = make(map[string]tankerkoenig.Station, len(stations))
+	e := newExporter(ctx, logger, apiClient, product, options...)
+	apiStations = e.uniqueStations(apiStations)
 
-	for _, station := range stations {
-		e.stations[station.Id] = station
+	e.search = &search{lat: lat, lng: lng, radius: radius}
+	e.logger.Info("searching for stations around location", "lat", lat, "lng", lng, "radius_km", radius)
+	e.pinned = apiStations
+
+	pinned, err := e.initialStationDetails(ctx, apiStations)
+	if err != nil {
+		return nil, err
 	}
+	found, err := e.searchStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n := e.excludeStations(found); n > 0 {
+		e.logger.Info("excluded stations around location", "excluded", n, "total", n+len(found))
+	}
+
+	stations := e.mergeStations(found, pinned)
+	if n := e.filterBrands(stations); len(e.brands) > 0 {
+		e.logger.Info("filtered stations by brand", "matched", len(stations), "total", n+len(stations))
+	}
+	e.setStations(stations)
+	e.ready.Store(true)
+
+	e.start()
 
 	return e, nil
 }
 
+// ValidateLocation returns an error if the given geohash isn't a valid location
+// to search for stations.
+func ValidateLocation(location string) error {
+	_, _, err := decodeLocation(location)
+	return err
+}
+
+// decodeLocation decodes the given geohash into its latitude and longitude. A
+// malformed geohash decodes to some unrelated location, so it is validated
+// first.
+func decodeLocation(location string) (lat, lng float64, err error) {
+	if len(location) == 0 {
+		return 0, 0, errors.New("empty geohash")
+	} else if len(location) > 12 {
+		return 0, 0, fmt.Errorf("invalid geohash %q: must not be longer than 12 characters", location)
+	} else if err := geohash.Validate(location); err != nil {
+		return 0, 0, fmt.Errorf("invalid geohash %q: %w", location, err)
+	}
+	lat, lng = geohash.Decode(location)
+	return lat, lng, nil
+}
+
+// encodeLocation encodes the given latitude and longitude into a geohash. It
+// reports false for coordinates out of range, which includes (0, 0) as the API
+// reports it for stations with unknown coordinates. Such coordinates would
+// encode to a meaningless geohash.
+func encodeLocation(lat, lng float64) (string, bool) {
+	if !(lat >= -90 && lat <= 90) || !(lng >= -180 && lng <= 180) || lat == 0 && lng == 0 {
+		return "", false
+	}
+	return geohash.Encode(lat, lng), true
+}
+
+// uniqueStations returns the given station IDs without duplicates, preserving
+// their order, to not retrieve the same station multiple times.
+func (e *Exporter) uniqueStations(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+	if n := len(ids) - len(unique); n > 0 {
+		e.logger.Warn("removed duplicate stations", "duplicates", n)
+	}
+	return unique
+}
+
+// stationDetails retrieves the details of the stations with the given IDs. The
+// details are retrieved concurrently but limited to not flood the API.
+func (e *Exporter) stationDetails(ctx context.Context, ids []string) (map[string]tankerkoenig.Station, error) {
+	return e.retrieveStationDetails(ctx, ids, 1)
+}
+
+// retrieveStationDetails is like stationDetails but makes up to the given
+// amount of attempts to retrieve the details of each station.
+func (e *Exporter) retrieveStationDetails(ctx context.Context, ids []string, attempts int) (map[string]tankerkoenig.Station, error) {
+	var (
+		stations   = make(map[string]tankerkoenig.Station, len(ids))
+		stationsMu sync.Mutex
+	)
+	errGroup, gctx := errgroup.WithContext(ctx)
+	errGroup.SetLimit(e.detailConcurrency)
+	for _, id := range ids {
+		errGroup.Go(func(id string) func() error {
+			return func() error {
+				station, err := e.stationDetail(gctx, id, attempts)
+				if err != nil {
+					return err
+				}
+
+				stationsMu.Lock()
+				stations[id] = station
+				stationsMu.Unlock()
+
+				return nil
+			}
+		}(id))
+	}
+
+	if err := errGroup.Wait(); err != nil {
+		return nil, err
+	}
+
+	if e.cacheDir != "" {
+		e.writeDetailsCache(stations)
+	}
+
+	return stations, nil
+}
+
+// stationDetail retrieves the details of the station with the given ID. Failed
+// attempts are retried with exponential backoff, up to the given amount of
+// attempts, unless they failed definitively. A station the API doesn't know is
+// never retried.
+func (e *Exporter) stationDetail(ctx context.Context, id string, attempts int) (tankerkoenig.Station, error) {
+	for attempt := 1; ; attempt++ {
+		station, _, err := e.client.Station.DetailWithContext(ctx, id)
+		if err == nil && station.ID == "" {
+			return tankerkoenig.Station{}, fmt.Errorf("station %q was not found", id)
+		} else if err == nil {
+			return station, nil
+		} else if attempt >= attempts || !isTransientError(ctx, err) {
+			return tankerkoenig.Station{}, fmt.Errorf("could not retrieve station details for station %s: %w", id, err)
+		}
+
+		// Half of the backoff is random jitter, so the retries of the
+		// stations don't hit the API at once.
+		d := startupBackoff << (attempt - 1)
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2))) //nolint:gosec // Jitter doesn't need to be secure.
+		e.logger.Warn("cannot retrieve station details, retrying", "station", id, "attempt", attempt, "backoff", d, "err", err)
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return tankerkoenig.Station{}, fmt.Errorf("could not retrieve station details for station %s: %w", id, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// isTransientError reports whether a request failed due to a transient error
+// and is worth retrying. Those are network errors, server errors and rate
+// limits. Errors the API reports otherwise are definitive.
+func isTransientError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var errResp *tankerkoenig.ErrorResponse
+	if errors.As(err, &errResp) {
+		code := errResp.Response.StatusCode
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+	return true
+}
+
+// searchStations lists the stations around the location the exporter was
+// created for. Stations not offering the selected product are left out, which
+// the API already does if a single product is selected.
+func (e *Exporter) searchStations(ctx context.Context) (map[string]tankerkoenig.Station, error) {
+	list, _, err := e.client.Station.ListSortedWithContext(ctx, e.search.lat, e.search.lng, e.search.radius, e.product, e.sort)
+	if err != nil {
+		return nil, fmt.Errorf("could not list stations: %w", err)
+	}
+
+	stations := make(map[string]tankerkoenig.Station, len(list))
+	for _, station := range list {
+		if !hasProduct(station, e.product) {
+			continue
+		}
+		stations[station.ID] = station
+	}
+
+	return stations, nil
+}
+
+// setStations replaces the monitored stations with the given ones. It must be
+// called with e.mutex held, if the exporter is already started.
+func (e *Exporter) setStations(stations map[string]tankerkoenig.Station) {
+	e.stations = stations
+	e.monitoredStations.Set(float64(len(stations)))
+	e.storePrices()
+}
+
+// excludeStations removes the excluded stations from the given stations and
+// returns how many were removed.
+func (e *Exporter) excludeStations(stations map[string]tankerkoenig.Station) int {
+	var n int
+	for id := range e.excluded {
+		if _, ok := stations[id]; ok {
+			delete(stations, id)
+			n++
+		}
+	}
+	return n
+}
+
+// filterBrands removes the stations not matching the brand filter from the
+// given stations and returns how many were removed.
+func (e *Exporter) filterBrands(stations map[string]tankerkoenig.Station) int {
+	if len(e.brands) == 0 {
+		return 0
+	}
+
+	var n int
+	for id, station := range stations {
+		if _, ok := e.brands[strings.ToLower(strings.TrimSpace(station.Brand))]; !ok {
+			delete(stations, id)
+			n++
+		}
+	}
+	return n
+}
+
 // Describe all the metrics collected by the Tankerkoenig exporter.
 // Implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
@@ -90,21 +761,75 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.scrapeDuration.Describe(ch)
 	e.failedScrapes.Describe(ch)
 	e.totalScrapes.Describe(ch)
+	e.failedBatches.Describe(ch)
+	e.consecutiveFailures.Describe(ch)
+	e.priceBatches.Describe(ch)
+	e.priceBatchErrors.Describe(ch)
+	e.cacheAge.Describe(ch)
+	e.monitoredStations.Describe(ch)
+	e.lastSuccess.Describe(ch)
 	ch <- e.priceDesc
+	ch <- e.spreadDesc
 	ch <- e.openDesc
-	ch <- e.detailsDesc
+	if e.details {
+		ch <- e.detailsDesc
+	}
+	ch <- e.distanceDesc
+	ch <- e.isOpenDesc
+	ch <- e.wholeDayOpenDesc
+	ch <- e.scheduledOpenDesc
+	ch <- e.priceChangesDesc
+	ch <- e.priceUpdatedDesc
+	if e.baselineWindow > 0 {
+		ch <- e.baselineDesc
+	}
+	ch <- e.scrapeErrorDesc
+	ch <- e.statusDesc
+	ch <- e.cheapestPriceDesc
+	ch <- e.cheapestStationDesc
+	ch <- e.licenseDesc
+	ch <- e.configInfoDesc
+	ch <- e.stationsByBrandDesc
+	ch <- e.inProgressDesc
 }
 
 // Collect the stats from the Tankerkoenig API.
 // Implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.CollectWithContext(e.ctx, ch)
+}
+
+// CollectWithContext is like Collect but aborts the API requests when the given
+// context is canceled, e.g. because the scrape timeout passed. The prices of
+// the batches retrieved until then are still exported.
+func (e *Exporter) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	// Abort the API requests if either the given or the exporter's context is
+	// canceled.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(e.ctx, cancel)
+	defer stop()
+
+	// Counted before acquiring the lock, so collects waiting for a hung one
+	// are reflected as well.
+	e.inProgress.Add(1)
+	defer e.inProgress.Add(-1)
+
 	// Protect metrics from concurrent collects.
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	// Scrape metrics from Tankerkoenig API.
-	if err := e.scrape(ch); err != nil {
-		e.logger.Printf("error: cannot scrape tankerkoenig api: %v", err)
+	if e.pollInterval > 0 {
+		// Serve the prices retrieved by the last successful poll, if any,
+		// without contacting the API.
+		if !e.cachedAt.IsZero() {
+			e.cacheAge.Set(time.Since(e.cachedAt).Seconds())
+			e.collectPrices(ch, e.cachedPrices, time.Now())
+			e.cacheAge.Collect(ch)
+		}
+	} else if err := e.scrape(ctx, ch); err != nil {
+		// Scrape metrics from Tankerkoenig API.
+		e.logger.Error("cannot scrape tankerkoenig api", "err", err)
 	}
 
 	// Collect metrics.
@@ -112,10 +837,106 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.scrapeDuration.Collect(ch)
 	e.failedScrapes.Collect(ch)
 	e.totalScrapes.Collect(ch)
+	e.failedBatches.Collect(ch)
+	e.consecutiveFailures.Collect(ch)
+	e.priceBatches.Collect(ch)
+	e.priceBatchErrors.Collect(ch)
+	e.monitoredStations.Collect(ch)
+	e.lastSuccess.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(e.inProgressDesc, prometheus.GaugeValue, float64(e.inProgress.Load()))
+
+	if e.license != "" {
+		ch <- prometheus.MustNewConstMetric(e.licenseDesc, prometheus.GaugeValue, 1, e.license)
+	}
+
+	var radius string
+	if e.search != nil {
+		radius = strconv.Itoa(e.search.radius)
+	}
+	ch <- prometheus.MustNewConstMetric(e.configInfoDesc, prometheus.GaugeValue, 1,
+		e.mode(), radius, e.product, e.pollInterval.String())
+
+	// Computed on every collect to reflect stations added or removed by a
+	// refresh.
+	byBrand := make(map[string]int)
+	for _, station := range e.stations {
+		brand := strings.TrimSpace(e.normalizeName(station.Brand))
+		if brand == "" {
+			brand = "unknown"
+		}
+		byBrand[brand]++
+	}
+	for brand, n := range byBrand {
+		ch <- prometheus.MustNewConstMetric(e.stationsByBrandDesc, prometheus.GaugeValue, float64(n), brand)
+	}
+}
+
+// WithContext returns a collector for the exporter that collects it with the
+// given context. See CollectWithContext.
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{exporter: e, ctx: ctx}
+}
+
+// contextCollector collects an exporter with a context.
+type contextCollector struct {
+	exporter *Exporter
+	ctx      context.Context
 }
 
-// scrape performs the API call and meassures its duration.
-func (e *Exporter) scrape(ch chan<- prometheus.Metric) error {
+// Describe implements prometheus.Collector.
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.CollectWithContext(c.ctx, ch)
+}
+
+// scrape retrieves the prices of the monitored stations and exports them. It
+// must be called with e.mutex held.
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ids := e.stationIDs()
+	ctx, span := tracer.Start(ctx, "scrape", trace.WithAttributes(attribute.Int("tankerkoenig.stations", len(ids))))
+	defer span.End()
+
+	prices, missing, license, err := e.fetchPrices(ctx, ids)
+	e.lastScrapeOK.Store(err == nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	e.dropMissing(prices, missing)
+	if license != "" {
+		e.license = license
+	}
+	e.cachedPrices = prices
+	e.cachedAt = time.Now()
+	e.cachedTraceID = span.SpanContext().TraceID()
+	e.lastSuccess.Set(float64(e.cachedAt.Unix()))
+	e.storePrices()
+	e.observePrices(prices)
+
+	e.collectPrices(ch, prices, e.cachedAt)
+
+	return nil
+}
+
+// stationIDs returns the IDs of the monitored stations. It must be called with
+// e.mutex held.
+func (e *Exporter) stationIDs() []string {
+	ids := make([]string, 0, len(e.stations))
+	for id := range e.stations {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// fetchPrices performs the API calls for the prices of the stations with the
+// given IDs and meassures their duration. It also returns the IDs of the
+// stations the API left out of its responses and the license of the prices
+// reported by the API.
+func (e *Exporter) fetchPrices(ctx context.Context, ids []string) (map[string]tankerkoenig.Price, []string, string, error) {
 	// Meassure scrape duration.
 	defer func(begun time.Time) {
 		e.scrapeDuration.Set(time.Since(begun).Seconds())
@@ -123,72 +944,237 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) error {
 
 	e.totalScrapes.Inc()
 
-	// Extract station IDs for price request.
-	ids := make([]string, 0, len(e.stations))
-	for id := range e.stations {
-		ids = append(ids, id)
+	// Bound the whole scrape, as many batches can take long in total even
+	// though every single request finishes in time.
+	parent := ctx
+	if e.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.scrapeTimeout)
+		defer cancel()
 	}
 
 	// Retrieve prices for specified stations. Since the API will only allow for
-	// ten stations to be queried with one request, we work them of in batches
-	// of ten.
-	const batchSize = 10
+	// ten stations to be queried with one request, we work them of in batches.
+	// A failed batch doesn't fail the whole scrape, the prices of the other
+	// batches are still exported.
 	var (
-		prices   = make(map[string]tankerkoenig.Price, len(ids))
-		pricesMu sync.Mutex
-		errGroup errgroup.Group
+		prices        = make(map[string]tankerkoenig.Price, len(ids))
+		missing       []string
+		license       string
+		pricesMu      sync.Mutex
+		batches       int
+		failedBatches int
+		errGroup      errgroup.Group
 	)
-	for i := 0; i < len(ids); i += batchSize {
-		j := i + batchSize
+	errGroup.SetLimit(e.maxConcurrency)
+	for i := 0; i < len(ids); i += e.batchSize {
+		j := i + e.batchSize
 		if j > len(ids) {
 			j = len(ids)
 		}
 
+		batches++
 		errGroup.Go(func(batch []string) func() error {
 			return func() error {
-				batchPrices, _, err := e.client.Prices.Get(batch...)
+				batchPrices, resp, err := e.client.Prices.GetWithContext(ctx, batch...)
+
+				pricesMu.Lock()
+				defer pricesMu.Unlock()
+
 				if err != nil {
+					failedBatches++
+					e.failedBatches.Inc()
+					e.logger.Error("cannot retrieve prices for stations", "station_ids", strings.Join(batch, ","), "err", err)
 					return err
 				}
 
-				pricesMu.Lock()
+				// The API might leave out requested stations, e.g. if they
+				// were closed down, or report ones that weren't requested.
+				requested := make(map[string]struct{}, len(batch))
+				for _, id := range batch {
+					requested[id] = struct{}{}
+					if _, ok := batchPrices[id]; !ok {
+						e.logger.Warn("station is missing from prices response", "station_id", id)
+						missing = append(missing, id)
+					}
+				}
 				for k, v := range batchPrices {
+					if _, ok := requested[k]; !ok {
+						e.logger.Warn("prices response contains unrequested station, skipping", "station_id", k)
+						continue
+					}
 					prices[k] = v
 				}
-				pricesMu.Unlock()
+				if resp.License != "" {
+					license = resp.License
+				}
 
 				return nil
 			}
 		}(ids[i:j]))
 	}
 
-	if err := errGroup.Wait(); err != nil {
+	// Only fail the scrape if no prices could be retrieved at all or it timed
+	// out, as the prices would be incomplete.
+	err := errGroup.Wait()
+	e.priceBatches.Set(float64(batches))
+	e.priceBatchErrors.Set(float64(failedBatches))
+	timedOut := e.scrapeTimeout > 0 && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		err = fmt.Errorf("scrape timed out after %s", e.scrapeTimeout)
+	}
+	if timedOut || err != nil && failedBatches == batches {
 		e.up.Set(0)
 		e.failedScrapes.Inc()
-		return err
+		e.consecutiveFailures.Inc()
+		return nil, nil, "", err
 	}
 
-	// Set metric values.
+	// Scrape was successful.
+	e.up.Set(1)
+	e.consecutiveFailures.Set(0)
+	e.logger.Debug("retrieved prices", "stations", len(prices), "batches", batches, "failed_batches", failedBatches)
+
+	return prices, missing, license, nil
+}
+
+// dropMissing counts the consecutive scrapes the given missing stations were
+// left out of the prices response and stops monitoring the ones missing for
+// too long. Stations with prices start counting anew. Stations of failed
+// batches are neither. It must be called with e.mutex held.
+func (e *Exporter) dropMissing(prices map[string]tankerkoenig.Price, missing []string) {
+	if e.dropMissingAfter <= 0 {
+		return
+	}
+
+	for id := range prices {
+		delete(e.absences, id)
+	}
+
+	var dropped bool
+	for _, id := range missing {
+		e.absences[id]++
+		if e.absences[id] < e.dropMissingAfter {
+			continue
+		}
+
+		delete(e.absences, id)
+		delete(e.stations, id)
+		// Cloned, as refreshes might still use the current pinned stations.
+		e.pinned = slices.DeleteFunc(slices.Clone(e.pinned), func(pinned string) bool { return pinned == id })
+		dropped = true
+		e.logger.Warn("station was missing from prices responses, no longer monitoring it", "station_id", id, "scrapes", e.dropMissingAfter)
+	}
+	if dropped {
+		e.monitoredStations.Set(float64(len(e.stations)))
+	}
+}
+
+// collectPrices exports the given prices of the monitored stations along with
+// their state recorded by observePrices. The opening times are evaluated at the
+// given time. It must be called with e.mutex held.
+func (e *Exporter) collectPrices(ch chan<- prometheus.Metric, prices map[string]tankerkoenig.Price, now time.Time) {
+	// The cheapest price of every product among the open stations. Only
+	// tracked for stations that originate from a location search.
+	cheapest := make(map[string]cheapestPrice, len(products))
+
 	for id, price := range prices {
-		station := e.stations[id]
+		// Stations might have been removed since the prices were retrieved.
+		station, ok := e.stations[id]
+		if !ok {
+			e.logger.Debug("station is no longer monitored, skipping", "station_id", id)
+			continue
+		}
+
+		// Open stations that report none of the selected products don't offer
+		// them and are skipped entirely. Closed stations report no
+		// prices at all, so they are kept.
+		if price.Status == "open" && !e.offersProduct(price) {
+			e.logger.Debug("station offers none of the selected products, skipping", "station_id", id, "station_name", station.Name)
+			continue
+		}
 
 		// Station metadata. We do some string manipulation on the address and
 		// city to make it look nicer as the come in all uppercase.
-		city := strings.TrimSpace(caser.String(station.Place))
-		street := strings.TrimSpace(caser.String(station.Street))
-		no := strings.TrimSpace(station.HouseNumber)
-		address := fmt.Sprintf("%s %s", street, no)
-		ch <- prometheus.MustNewConstMetric(e.detailsDesc, prometheus.GaugeValue, 1, id,
-			station.Name,
-			address,
-			city,
-			geohash.Encode(station.Lat, station.Lng),
-			station.Brand,
-		)
+		if e.details {
+			city := strings.TrimSpace(caser.String(station.Place))
+			street := strings.TrimSpace(caser.String(station.Street))
+			no := strings.TrimSpace(station.HouseNumber)
+			address := fmt.Sprintf("%s %s", street, no)
+			// The post code is unknown if zero and the state is only known
+			// for stations whose details were retrieved.
+			var postCode string
+			if station.PostCode != 0 {
+				postCode = fmt.Sprintf("%05d", station.PostCode)
+			}
+			location, ok := encodeLocation(station.Lat, station.Lng)
+			if !ok {
+				e.logger.Debug("station has invalid coordinates, leaving geohash empty", "station_id", id, "lat", station.Lat, "lng", station.Lng)
+			}
+			ch <- prometheus.MustNewConstMetric(e.detailsDesc, prometheus.GaugeValue, 1, id,
+				e.stationName(id, station),
+				address,
+				city,
+				location,
+				e.normalizeName(station.Brand),
+				postCode,
+				strings.TrimSpace(station.State),
+			)
+		}
+
+		// Distance from the search location. Only known for stations that
+		// originate from a location search.
+		if e.search != nil {
+			ch <- prometheus.MustNewConstMetric(e.distanceDesc, prometheus.GaugeValue, station.Dist, id)
+
+			// The open status reported by the location search is only as
+			// recent as the search, unlike the status of the prices.
+			var isOpen float64
+			if station.IsOpen {
+				isOpen = 1
+			}
+			ch <- prometheus.MustNewConstMetric(e.isOpenDesc, prometheus.GaugeValue, isOpen, id)
+			if station.IsOpen != (price.Status == "open") {
+				e.logger.Debug("open status of station disagrees with its prices", "station_id", id, "is_open", station.IsOpen, "status", price.Status)
+			}
+		}
+
+		// Station opening times. Only known for stations whose details were
+		// retrieved.
+		if hasSchedule(station) {
+			var wholeDay, open float64
+			if station.WholeDay {
+				wholeDay = 1
+			}
+			if scheduledOpen(station, now) {
+				open = 1
+			}
+			ch <- prometheus.MustNewConstMetric(e.wholeDayOpenDesc, prometheus.GaugeValue, wholeDay, id)
+			ch <- prometheus.MustNewConstMetric(e.scheduledOpenDesc, prometheus.GaugeValue, open, id)
+		}
+
+		// Station status as reported by the API. Unknown statuses are
+		// exported as well, so exactly one status is 1.
+		status := price.Status
+		if status == "" {
+			status = "unknown"
+		}
+		known := false
+		for _, s := range statuses {
+			var v float64
+			if s == status {
+				v, known = 1, true
+			}
+			ch <- prometheus.MustNewConstMetric(e.statusDesc, prometheus.GaugeValue, v, id, s)
+		}
+		if !known {
+			ch <- prometheus.MustNewConstMetric(e.statusDesc, prometheus.GaugeValue, 1, id, status)
+		}
 
 		// Station status.
 		if stat := price.Status; stat == "no prices" {
-			e.logger.Printf("warning: station %q (%s) has no prices, skipping...", id, station.Name)
+			e.logger.Debug("station has no prices, skipping", "station_id", id, "station_name", station.Name)
+			ch <- prometheus.MustNewConstMetric(e.scrapeErrorDesc, prometheus.GaugeValue, 1, id)
 			continue
 		} else if stat == "open" {
 			ch <- prometheus.MustNewConstMetric(e.openDesc, prometheus.GaugeValue, 1, id)
@@ -196,70 +1182,494 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) error {
 			ch <- prometheus.MustNewConstMetric(e.openDesc, prometheus.GaugeValue, 0, id)
 		}
 
-		// Station prices.
-		if v, ok := price.Diesel.(float64); ok {
-			ch <- prometheus.MustNewConstMetric(e.priceDesc, prometheus.GaugeValue, v, id, "diesel")
+		// Station prices. Only the selected product is exported.
+		var exported int
+		stationPrices := make(map[string]float64, len(products))
+		for _, product := range products {
+			if !e.includesProduct(product) {
+				continue
+			}
+			v, ok, err := productPrice(price, product)
+			if err != nil {
+				e.logger.Warn("station has an invalid price", "station_id", id, "station_name", station.Name, "product", product, "err", err)
+				continue
+			} else if !ok {
+				continue
+			}
+			v = roundPrice(v, e.pricePrecision)
+
+			ch <- prometheus.MustNewConstMetric(e.priceDesc, prometheus.GaugeValue, e.inPriceUnit(v), e.priceLabelValues(station, id, product)...)
+			stationPrices[product] = v
+			exported++
+
+			if e.search != nil && price.Status == "open" {
+				// Ties are broken by the distance from the search location.
+				if c, ok := cheapest[product]; !ok || v < c.price || (v == c.price && station.Dist < c.dist) {
+					cheapest[product] = cheapestPrice{id: id, price: v, dist: station.Dist}
+				}
+			}
+
+			state := e.priceStates[priceKey{id: id, product: product}]
+			changes := prometheus.MustNewConstMetric(e.priceChangesDesc, prometheus.CounterValue, float64(state.changes), id, product)
+			if state.traceID.IsValid() {
+				// Links the price to the trace of the scrape that retrieved it.
+				// Gauges can't carry exemplars, so they are attached to the
+				// price changes.
+				changes = prometheus.MustNewMetricWithExemplars(changes, prometheus.Exemplar{
+					Value:     float64(state.changes),
+					Labels:    prometheus.Labels{"trace_id": state.traceID.String(), "id": id},
+					Timestamp: state.since,
+				})
+			}
+			ch <- changes
+			ch <- prometheus.MustNewConstMetric(e.priceUpdatedDesc, prometheus.GaugeValue, float64(state.since.Unix()), id, product)
+			if e.baselineWindow > 0 {
+				if m := state.recentMin(); m > 0 {
+					ch <- prometheus.MustNewConstMetric(e.baselineDesc, prometheus.GaugeValue, v/m, id, product)
+				}
+			}
+		}
+
+		// Price spreads, only if both prices are known.
+		for _, pair := range spreadPairs {
+			a, okA := stationPrices[pair[0]]
+			b, okB := stationPrices[pair[1]]
+			if !okA || !okB {
+				continue
+			}
+			spread := roundPrice(a-b, e.pricePrecision)
+			ch <- prometheus.MustNewConstMetric(e.spreadDesc, prometheus.GaugeValue, e.inPriceUnit(spread), id, pair[0]+"_"+pair[1])
 		}
-		if v, ok := price.E5.(float64); ok {
-			ch <- prometheus.MustNewConstMetric(e.priceDesc, prometheus.GaugeValue, v, id, "e5")
+
+		// A station without a known status or an open one without prices
+		// didn't produce usable data. Closed stations don't report prices.
+		var scrapeErr float64
+		if !known || price.Status == "open" && exported == 0 {
+			scrapeErr = 1
 		}
-		if v, ok := price.E10.(float64); ok {
-			ch <- prometheus.MustNewConstMetric(e.priceDesc, prometheus.GaugeValue, v, id, "e10")
+		ch <- prometheus.MustNewConstMetric(e.scrapeErrorDesc, prometheus.GaugeValue, scrapeErr, id)
+	}
+
+	// Stations that are absent from the response didn't produce usable data
+	// either.
+	for id := range e.stations {
+		if _, ok := prices[id]; !ok {
+			ch <- prometheus.MustNewConstMetric(e.scrapeErrorDesc, prometheus.GaugeValue, 1, id)
 		}
 	}
 
-	// Scrape was successful.
-	e.up.Set(1)
+	for product, c := range cheapest {
+		ch <- prometheus.MustNewConstMetric(e.cheapestPriceDesc, prometheus.GaugeValue, e.inPriceUnit(c.price), product)
+		ch <- prometheus.MustNewConstMetric(e.cheapestStationDesc, prometheus.GaugeValue, 1, product, c.id)
+	}
+}
 
-	return nil
+// mode returns how the monitored stations are selected: "stations" if they are
+// given by their IDs, "location" if they are searched around a location or
+// "combined" for both. It must be called with e.mutex held.
+func (e *Exporter) mode() string {
+	switch {
+	case e.search != nil && len(e.pinned) > 0:
+		return "combined"
+	case e.search != nil:
+		return "location"
+	default:
+		return "stations"
+	}
+}
+
+// Ready reports whether the exporter has retrieved its initial station details
+// and the most recent scrape of the Tankerkoenig API was successful, so it is
+// not ready until the first successful scrape. It doesn't wait for a scrape in
+// progress.
+func (e *Exporter) Ready() bool {
+	return e.ready.Load() && e.lastScrapeOK.Load()
+}
+
+// inPriceUnit converts the given price in euro into the unit prices are
+// exported in.
+func (e *Exporter) inPriceUnit(price float64) float64 {
+	if e.priceUnit == "cent" {
+		// Rounding avoids floating point noise from the conversion.
+		decimals := e.pricePrecision - 2
+		if decimals < 0 {
+			decimals = 0
+		}
+		return roundPrice(price*100, decimals)
+	}
+	return price
 }
 
-func newExporter(logger *log.Logger, apiClient *client.Client) *Exporter {
-	return &Exporter{
+// priceLabelValues returns the values of the price labels for the given
+// product of the given station.
+func (e *Exporter) priceLabelValues(station tankerkoenig.Station, id, product string) []string {
+	values := make([]string, 0, len(e.priceLabels))
+	for _, label := range e.priceLabels {
+		switch label {
+		case "id":
+			values = append(values, id)
+		case "product":
+			values = append(values, product)
+		case "name":
+			values = append(values, e.stationName(id, station))
+		case "city":
+			values = append(values, strings.TrimSpace(caser.String(station.Place)))
+		case "brand":
+			values = append(values, e.normalizeName(station.Brand))
+		}
+	}
+	return values
+}
+
+// stationName returns the name the station with the given ID is exported with.
+// Names given by WithNames take precedence over the ones reported by the API.
+func (e *Exporter) stationName(id string, station tankerkoenig.Station) string {
+	if name, ok := e.names[id]; ok {
+		return name
+	}
+	return e.normalizeName(station.Name)
+}
+
+// normalizeName title cases the given station name or brand, keeping known
+// acronyms upper case, unless names are exported as reported by the API.
+func (e *Exporter) normalizeName(name string) string {
+	if e.rawNames {
+		return name
+	}
+	words := strings.Fields(name)
+	for i, word := range words {
+		if _, ok := acronyms[strings.ToUpper(word)]; ok {
+			words[i] = strings.ToUpper(word)
+		} else {
+			words[i] = caser.String(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// roundPrice rounds the given price half away from zero to the given amount of
+// decimals.
+func roundPrice(price float64, decimals int) float64 {
+	p := math.Pow10(decimals)
+	return math.Round(price*p) / p
+}
+
+// includesProduct reports whether prices for the given product are exported.
+func (e *Exporter) includesProduct(product string) bool {
+	return e.product == "all" || e.product == product
+}
+
+// offersProduct reports whether the given prices include any of the exported
+// products. Products reporting a price that can't be parsed are offered, so the
+// invalid price is still reported.
+func (e *Exporter) offersProduct(price tankerkoenig.Price) bool {
+	for _, product := range products {
+		if !e.includesProduct(product) {
+			continue
+		}
+		if _, ok, err := productPrice(price, product); ok || err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// observePrices records the given prices of the monitored stations, retrieved
+// at e.cachedAt by the scrape or poll identified by e.cachedTraceID. It must be
+// called with e.mutex held.
+func (e *Exporter) observePrices(prices map[string]tankerkoenig.Price) {
+	for id, price := range prices {
+		if _, ok := e.stations[id]; !ok {
+			continue
+		}
+		for _, product := range products {
+			if !e.includesProduct(product) {
+				continue
+			}
+			if v, ok, err := productPrice(price, product); err == nil && ok {
+				e.observePrice(id, product, roundPrice(v, e.pricePrecision))
+			}
+		}
+	}
+}
+
+// observePrice records the given price of a stations product. It must be called
+// with e.mutex held.
+func (e *Exporter) observePrice(id, product string, price float64) {
+	key := priceKey{id: id, product: product}
+
+	state, ok := e.priceStates[key]
+	if !ok {
+		state.since = e.cachedAt
+		state.traceID = e.cachedTraceID
+	} else if state.price != price {
+		state.changes++
+		state.since = e.cachedAt
+		state.traceID = e.cachedTraceID
+	}
+	state.price = price
+	if e.baselineWindow > 0 {
+		state.sample(price, e.baselineWindow)
+	}
+	e.priceStates[key] = state
+}
+
+// hasProduct reports whether the given station, as returned by a location
+// search, offers the given product. Stations that don't offer a product report
+// no price for it.
+func hasProduct(station tankerkoenig.Station, product string) bool {
+	if product == "all" {
+		return true
+	}
+	_, ok, _ := productPrice(tankerkoenig.Price{
+		Diesel: station.Diesel,
+		E5:     station.E5,
+		E10:    station.E10,
+	}, product)
+	return ok
+}
+
+// productPrice returns the price of the given product. It reports false if the
+// price is not available. The API usually reports prices as numbers but
+// sometimes as strings, which are parsed.
+func productPrice(price tankerkoenig.Price, product string) (float64, bool, error) {
+	var v any
+	switch product {
+	case "diesel":
+		v = price.Diesel
+	case "e5":
+		v = price.E5
+	case "e10":
+		v = price.E10
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return v, true, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return f, true, nil
+	default:
+		// Products that aren't offered report false instead of a price.
+		return 0, false, nil
+	}
+}
+
+func newExporter(ctx context.Context, logger *slog.Logger, apiClient *client.Client, product string, options ...Option) *Exporter {
+	e := &Exporter{
+		ctx:    ctx,
 		logger: logger,
 
-		client: apiClient,
-
-		up: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "up",
-			Help:      "Was the last scrape of the Tankerkoenig API successful?",
-		}),
-		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "exporter",
-			Name:      "scrape_duration_seconds",
-			Help:      "Duration of the scrape of metrics from the Tankerkoenig API.",
-		}),
-		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Subsystem: "exporter",
-			Name:      "scrapes_total",
-			Help:      "Total Tankerkoenig API scrapes.",
-		}),
-		failedScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Subsystem: "exporter",
-			Name:      "scrape_failures_total",
-			Help:      "Total amount of scrape failures.",
-		}),
-		priceDesc: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "station", "price_euro"),
-			"Gas prices in EURO (€).",
-			[]string{"id", "product"},
-			nil,
-		),
-		openDesc: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "station", "open"),
-			"Status of the station. 1 for OPEN, 0 for CLOSED.",
-			[]string{"id"},
-			nil,
-		),
-		detailsDesc: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "station", "details"),
-			"Associated details of a station. Always 1.",
-			[]string{"id", "name", "address", "city", "geohash", "brand"},
-			nil,
-		),
+		client:      apiClient,
+		product:     product,
+		priceStates: make(map[priceKey]priceState),
+		absences:    make(map[string]int),
+		excluded:    make(map[string]struct{}),
+		brands:      make(map[string]struct{}),
+
+		detailConcurrency: 4,
+		batchSize:         MaxBatchSize,
+		maxConcurrency:    4,
+		pricePrecision:    3,
+		namespace:         DefaultNamespace,
+		details:           true,
+		priceLabels:       []string{"id", "product"},
+		sort:              "dist",
+		requireStations:   true,
 	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	e.up = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Name:      "up",
+		Help:      "Was the last scrape of the Tankerkoenig API successful?",
+	})
+	e.scrapeDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "scrape_duration_seconds",
+		Help:      "Duration of the scrape of metrics from the Tankerkoenig API.",
+	})
+	e.totalScrapes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "scrapes_total",
+		Help:      "Total Tankerkoenig API scrapes.",
+	})
+	e.failedScrapes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "scrape_failures_total",
+		Help:      "Total amount of scrape failures.",
+	})
+	e.failedBatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "batch_failures_total",
+		Help:      "Total amount of failed price requests for a batch of stations.",
+	})
+	e.consecutiveFailures = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "consecutive_scrape_failures",
+		Help:      "Amount of scrape failures since the last successful scrape.",
+	})
+	e.priceBatches = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "price_batches",
+		Help:      "Amount of batches the prices were retrieved in by the last scrape.",
+	})
+	e.priceBatchErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "price_batch_errors",
+		Help:      "Amount of batches whose prices couldn't be retrieved by the last scrape.",
+	})
+	e.cacheAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "cache_age_seconds",
+		Help:      "Age of the polled prices served from the cache.",
+	})
+	e.monitoredStations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "monitored_stations",
+		Help:      "Amount of monitored stations.",
+	})
+	e.lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: e.namespace,
+		Subsystem: "exporter",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful scrape of the Tankerkoenig API.",
+	})
+	e.openDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "open"),
+		"Status of the station. 1 for OPEN, 0 for CLOSED.",
+		[]string{"id"},
+		nil,
+	)
+	e.detailsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "details"),
+		"Associated details of a station. Always 1.",
+		[]string{"id", "name", "address", "city", "geohash", "brand", "postcode", "state"},
+		nil,
+	)
+	e.priceChangesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "price_changes_total"),
+		"Total amount of price changes observed.",
+		[]string{"id", "product"},
+		nil,
+	)
+	e.priceUpdatedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "price_updated_timestamp_seconds"),
+		"Unix timestamp at which the current price was first observed.",
+		[]string{"id", "product"},
+		nil,
+	)
+	e.baselineDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "price_vs_recent_min_ratio"),
+		"Current price divided by the minimum price of the recent scrapes, including the current one. 1 if the price is the lowest recently.",
+		[]string{"id", "product"},
+		nil,
+	)
+	e.statusDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "status"),
+		"Status of the station as reported by the API. 1 for the current status, 0 for the others.",
+		[]string{"id", "status"},
+		nil,
+	)
+	e.scrapeErrorDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "scrape_error"),
+		"Whether the last scrape produced no usable data for the station. Closed stations without prices are OK. 1 for ERROR, 0 for OK.",
+		[]string{"id"},
+		nil,
+	)
+	e.wholeDayOpenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "whole_day_open"),
+		"Whether the station is open around the clock. 1 for YES, 0 for NO.",
+		[]string{"id"},
+		nil,
+	)
+	e.scheduledOpenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "scheduled_open"),
+		"Status of the station according to its opening times. 1 for OPEN, 0 for CLOSED.",
+		[]string{"id"},
+		nil,
+	)
+	e.isOpenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "is_open"),
+		"Status of the station as reported by the last location search. 1 for OPEN, 0 for CLOSED.",
+		[]string{"id"},
+		nil,
+	)
+	e.distanceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "distance_km"),
+		"Air-line distance of the station from the search location in kilometers.",
+		[]string{"id"},
+		nil,
+	)
+	e.licenseDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "exporter", "api_license_info"),
+		"License of the data reported by the Tankerkoenig API. Always 1.",
+		[]string{"license"},
+		nil,
+	)
+	e.configInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "exporter", "config_info"),
+		"Configuration of the exporter: mode (stations, location or combined), search radius in km, product filter and price poll interval. Always 1.",
+		[]string{"mode", "radius", "product", "poll_interval"},
+		nil,
+	)
+	e.cheapestStationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "location", "cheapest_station_id"),
+		"Station with the cheapest price of the product among the open stations. Always 1.",
+		[]string{"product", "id"},
+		nil,
+	)
+	e.stationsByBrandDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "exporter", "stations_by_brand"),
+		"Amount of monitored stations by brand.",
+		[]string{"brand"},
+		nil,
+	)
+	e.inProgressDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "exporter", "scrape_in_progress"),
+		"Amount of scrapes in progress, including the current one.",
+		nil,
+		nil,
+	)
+
+	// The price metrics are named after the unit of the prices.
+	unit, unitHelp := "euro", "EURO (€)"
+	if e.priceUnit == "cent" {
+		unit, unitHelp = "cent", "CENT"
+	}
+	e.priceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "price_"+unit),
+		"Gas prices in "+unitHelp+".",
+		e.priceLabels,
+		nil,
+	)
+	e.spreadDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "station", "price_spread_"+unit),
+		"Difference between the prices of the pair of products in "+unitHelp+".",
+		[]string{"id", "pair"},
+		nil,
+	)
+	e.cheapestPriceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "location", "cheapest_price_"+unit),
+		"Cheapest price of the product among the open stations in "+unitHelp+".",
+		[]string{"product"},
+		nil,
+	)
+
+	return e
 }
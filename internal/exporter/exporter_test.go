@@ -0,0 +1,447 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/client"
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+// testAPI serves the station details and prices endpoints of the Tankerkoenig
+// API for any requested station.
+type testAPI struct {
+	mu sync.Mutex
+	// prices are the prices reported for the stations by ID. Stations without
+	// prices report the default prices, stations with nil prices are left out
+	// of the response.
+	prices map[string]map[string]any
+	// down fails all prices requests.
+	down bool
+
+	priceRequests atomic.Int64
+	inFlight      atomic.Int64
+	maxInFlight   atomic.Int64
+}
+
+func (api *testAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch r.URL.Path {
+	case "/json/detail.php":
+		id := query.Get("id")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":     true,
+			"status": "ok",
+			"station": map[string]any{
+				"id":    id,
+				"name":  "Station " + id,
+				"brand": "ESSO",
+				"place": "BERLIN",
+				"lat":   52.521,
+				"lng":   13.438,
+			},
+		})
+	case "/json/prices.php":
+		api.priceRequests.Add(1)
+		n := api.inFlight.Add(1)
+		defer api.inFlight.Add(-1)
+		for {
+			m := api.maxInFlight.Load()
+			if n <= m || api.maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		// Give concurrent requests a chance to overlap.
+		time.Sleep(time.Millisecond * 10)
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		if api.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		var ids []string
+		_ = json.Unmarshal([]byte(query.Get("ids")), &ids)
+		prices := make(map[string]any, len(ids))
+		for _, id := range ids {
+			price, ok := api.prices[id]
+			if !ok {
+				price = map[string]any{"status": "open", "e5": 1.789, "e10": 1.729, "diesel": 1.599}
+			} else if price == nil {
+				continue
+			}
+			prices[id] = price
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "prices": prices})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setPrices sets the prices reported for the station with the given ID.
+func (api *testAPI) setPrices(id string, prices map[string]any) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.prices == nil {
+		api.prices = make(map[string]map[string]any)
+	}
+	api.prices[id] = prices
+}
+
+// setup starts a test server serving the given API and returns a client for
+// it. The server is closed once the test finished.
+func setup(t *testing.T, api *testAPI) *client.Client {
+	t.Helper()
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.New("test-key", time.Second*5, client.WithBaseURL(baseURL))
+}
+
+// testLogger returns a logger discarding all logs.
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestExporter_Collect(t *testing.T) {
+	api := new(testAPI)
+	apiClient := setup(t, api)
+
+	// More stations than are retrieved by a single round of concurrent
+	// batches.
+	const (
+		maxConcurrency = 4
+		stations       = MaxBatchSize*maxConcurrency + 5
+		batches        = maxConcurrency + 1
+	)
+	ids := make([]string, stations)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("station-%02d", i)
+	}
+
+	e, err := NewForStations(context.Background(), testLogger(), apiClient, ids, "all",
+		WithMaxConcurrency(maxConcurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Ready() {
+		t.Error("Ready() = true before the first scrape, want false")
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	n, err := testutil.GatherAndCount(reg, "tk_station_price_euro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := stations * len(products); n != want {
+		t.Errorf("got %d price series, want %d", n, want)
+	}
+	if got := testutil.ToFloat64(e.up); got != 1 {
+		t.Errorf("tk_up = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(e.priceBatches); got != batches {
+		t.Errorf("tk_exporter_price_batches = %v, want %d", got, batches)
+	}
+	if got := testutil.ToFloat64(e.priceBatchErrors); got != 0 {
+		t.Errorf("tk_exporter_price_batch_errors = %v, want 0", got)
+	}
+	if got := api.priceRequests.Load(); got != batches {
+		t.Errorf("made %d prices requests, want %d", got, batches)
+	}
+	if got := api.maxInFlight.Load(); got > maxConcurrency {
+		t.Errorf("made %d concurrent prices requests, want at most %d", got, maxConcurrency)
+	}
+	if !e.Ready() {
+		t.Error("Ready() = false after a successful scrape, want true")
+	}
+
+	// A failed scrape marks the exporter as down and not ready.
+	api.mu.Lock()
+	api.down = true
+	api.mu.Unlock()
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(e.up); got != 0 {
+		t.Errorf("tk_up = %v after a failed scrape, want 0", got)
+	}
+	if got := testutil.ToFloat64(e.priceBatchErrors); got != batches {
+		t.Errorf("tk_exporter_price_batch_errors = %v, want %d", got, batches)
+	}
+	if e.Ready() {
+		t.Error("Ready() = true after a failed scrape, want false")
+	}
+}
+
+func TestExporter_Collect_Spreads(t *testing.T) {
+	api := &testAPI{
+		prices: map[string]map[string]any{
+			"a": {"status": "open", "e5": 1.789, "e10": 1.729, "diesel": 1.599},
+			// Spreads are only exported if both prices are known.
+			"b": {"status": "open", "e5": 1.809, "e10": false, "diesel": "1.659"},
+		},
+	}
+	apiClient := setup(t, api)
+
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{
+			unit: "euro",
+			want: `
+# HELP tk_station_price_spread_euro Difference between the prices of the pair of products in EURO (€).
+# TYPE tk_station_price_spread_euro gauge
+tk_station_price_spread_euro{id="a",pair="e10_diesel"} 0.13
+tk_station_price_spread_euro{id="a",pair="e5_diesel"} 0.19
+tk_station_price_spread_euro{id="a",pair="e5_e10"} 0.06
+tk_station_price_spread_euro{id="b",pair="e5_diesel"} 0.15
+`,
+		},
+		{
+			unit: "cent",
+			want: `
+# HELP tk_station_price_spread_cent Difference between the prices of the pair of products in CENT.
+# TYPE tk_station_price_spread_cent gauge
+tk_station_price_spread_cent{id="a",pair="e10_diesel"} 13
+tk_station_price_spread_cent{id="a",pair="e5_diesel"} 19
+tk_station_price_spread_cent{id="a",pair="e5_e10"} 6
+tk_station_price_spread_cent{id="b",pair="e5_diesel"} 15
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			e, err := NewForStations(context.Background(), testLogger(), apiClient, []string{"a", "b"}, "all",
+				WithPriceUnit(tt.unit))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name := "tk_station_price_spread_" + tt.unit
+			if err := testutil.CollectAndCompare(e, strings.NewReader(tt.want), name); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestExporter_Collect_ScrapeErrors(t *testing.T) {
+	api := &testAPI{
+		prices: map[string]map[string]any{
+			"broken": {"status": "open", "e5": "n/a", "e10": 1.729, "diesel": 1.599},
+			"closed": {"status": "closed", "e5": false, "e10": false, "diesel": false},
+			// Stations not offering the product are left out entirely.
+			"no-e5":   {"status": "open", "e5": false, "e10": 1.729, "diesel": 1.599},
+			"missing": nil,
+		},
+	}
+	apiClient := setup(t, api)
+
+	e, err := NewForStations(context.Background(), testLogger(), apiClient, []string{"ok", "broken", "closed", "no-e5", "missing"}, "e5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP tk_station_scrape_error Whether the last scrape produced no usable data for the station. Closed stations without prices are OK. 1 for ERROR, 0 for OK.
+# TYPE tk_station_scrape_error gauge
+tk_station_scrape_error{id="broken"} 1
+tk_station_scrape_error{id="closed"} 0
+tk_station_scrape_error{id="missing"} 1
+tk_station_scrape_error{id="ok"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(want), "tk_station_scrape_error"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporter_poll_PriceChanges(t *testing.T) {
+	api := new(testAPI)
+	apiClient := setup(t, api)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	e, err := NewForStations(ctx, testLogger(), apiClient, []string{"a"}, "e5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Polled by the test instead of in the background.
+	e.pollInterval = time.Hour
+
+	// Changes between collects are counted, even if the price changed back.
+	for _, price := range []float64{1.789, 1.759, 1.789} {
+		api.setPrices("a", map[string]any{"status": "open", "e5": price})
+		e.poll(ctx)
+	}
+	polledAt := e.cachedAt
+
+	want := fmt.Sprintf(`
+# HELP tk_station_price_changes_total Total amount of price changes observed.
+# TYPE tk_station_price_changes_total counter
+tk_station_price_changes_total{id="a",product="e5"} 2
+# HELP tk_station_price_updated_timestamp_seconds Unix timestamp at which the current price was first observed.
+# TYPE tk_station_price_updated_timestamp_seconds gauge
+tk_station_price_updated_timestamp_seconds{id="a",product="e5"} %d
+`, polledAt.Unix())
+	names := []string{"tk_station_price_changes_total", "tk_station_price_updated_timestamp_seconds"}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Collects only serve the recorded state.
+	if err := testutil.CollectAndCompare(e, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	tests := []struct {
+		price    float64
+		decimals int
+		want     float64
+	}{
+		{price: 1.789, decimals: 3, want: 1.789},
+		{price: 1.7894, decimals: 3, want: 1.789},
+		{price: 1.7895, decimals: 3, want: 1.79},
+		{price: 1.789, decimals: 2, want: 1.79},
+		{price: 1.789, decimals: 0, want: 2},
+		{price: -0.065, decimals: 2, want: -0.07},
+	}
+	for _, tt := range tests {
+		if got := roundPrice(tt.price, tt.decimals); got != tt.want {
+			t.Errorf("roundPrice(%v, %d) = %v, want %v", tt.price, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestExporter_inPriceUnit(t *testing.T) {
+	tests := []struct {
+		unit      string
+		precision int
+		price     float64
+		want      float64
+	}{
+		{unit: "euro", precision: 3, price: 1.789, want: 1.789},
+		// 1.789 * 100 is 178.89999999999998 without rounding.
+		{unit: "cent", precision: 3, price: 1.789, want: 178.9},
+		{unit: "cent", precision: 2, price: 1.79, want: 179},
+		{unit: "cent", precision: 1, price: 1.8, want: 180},
+	}
+	for _, tt := range tests {
+		e := newExporter(context.Background(), testLogger(), nil, "all",
+			WithPriceUnit(tt.unit), WithPricePrecision(tt.precision))
+		if got := e.inPriceUnit(tt.price); got != tt.want {
+			t.Errorf("inPriceUnit(%v) in %s with precision %d = %v, want %v", tt.price, tt.unit, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestProductPrice(t *testing.T) {
+	tests := []struct {
+		name    string
+		price   any
+		want    float64
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "number", price: 1.789, want: 1.789, wantOK: true},
+		{name: "string", price: "1.789", want: 1.789, wantOK: true},
+		{name: "string with spaces", price: " 1.789 ", want: 1.789, wantOK: true},
+		{name: "invalid string", price: "n/a", wantErr: true},
+		{name: "not offered", price: false},
+		{name: "missing", price: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := productPrice(tankerkoenig.Price{Status: "open", E5: tt.price}, "e5")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("productPrice() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("productPrice() = %v, %t, want %v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPriceState_Sample(t *testing.T) {
+	var state priceState
+	for _, price := range []float64{1.789, 1.759, 1.799} {
+		state.sample(price, 3)
+	}
+	if got, want := state.recentMin(), 1.759; got != want {
+		t.Errorf("recentMin() = %v, want %v", got, want)
+	}
+
+	// Once the window is full, the oldest prices are replaced.
+	state.sample(1.809, 3)
+	state.sample(1.819, 3)
+	if got, want := state.recent, []float64{1.809, 1.819, 1.799}; !slices.Equal(got, want) {
+		t.Errorf("recent = %v, want %v", got, want)
+	}
+	if got, want := state.recentMin(), 1.799; got != want {
+		t.Errorf("recentMin() = %v, want %v", got, want)
+	}
+}
+
+func TestExporter_dropMissing(t *testing.T) {
+	e := newExporter(context.Background(), testLogger(), nil, "all", WithDropMissing(2))
+	e.setStations(map[string]tankerkoenig.Station{"a": {}, "b": {}})
+	e.pinned = []string{"b"}
+	pinned := e.pinned
+
+	present := map[string]tankerkoenig.Price{"a": {Status: "open"}}
+
+	// A station is only dropped after missing from consecutive responses.
+	e.dropMissing(present, []string{"b"})
+	e.dropMissing(map[string]tankerkoenig.Price{"a": {}, "b": {}}, nil)
+	e.dropMissing(present, []string{"b"})
+	if _, ok := e.stations["b"]; !ok {
+		t.Fatal("station was dropped before missing from 2 consecutive responses")
+	}
+
+	e.dropMissing(present, []string{"b"})
+	if _, ok := e.stations["b"]; ok {
+		t.Error("station wasn't dropped after missing from 2 consecutive responses")
+	}
+	if _, ok := e.stations["a"]; !ok {
+		t.Error("present station was dropped")
+	}
+	if len(e.pinned) != 0 {
+		t.Errorf("pinned = %v, want none", e.pinned)
+	}
+	if len(pinned) != 1 {
+		t.Error("previous pinned stations were modified")
+	}
+	if got := testutil.ToFloat64(e.monitoredStations); got != 1 {
+		t.Errorf("tk_exporter_monitored_stations = %v, want 1", got)
+	}
+}
@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"sort"
+	"time"
+)
+
+// StationPrices are the current prices of a monitored station. The name and
+// brand are the ones the station's metrics are labeled with.
+type StationPrices struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Brand   string `json:"brand"`
+	Geohash string `json:"geohash"`
+	Open    bool   `json:"open"`
+	// Prices are the prices by product in the unit they are exported in.
+	// Products without a price are left out.
+	Prices map[string]float64 `json:"prices"`
+}
+
+// pricesSnapshot are the prices of the monitored stations at the time they
+// were retrieved.
+type pricesSnapshot struct {
+	stations []StationPrices
+	at       time.Time
+}
+
+// Prices returns the current prices of the monitored stations, sorted by
+// station ID, and the time they were retrieved at. They are taken from the last
+// successful scrape or poll, so no API requests are made. The time is zero if
+// there was none yet. It doesn't wait for a scrape in progress.
+func (e *Exporter) Prices() ([]StationPrices, time.Time) {
+	snapshot := e.snapshot.Load()
+	if snapshot == nil {
+		return []StationPrices{}, time.Time{}
+	}
+	return snapshot.stations, snapshot.at
+}
+
+// storePrices takes a snapshot of the cached prices of the monitored stations
+// for Prices, so it doesn't need to wait for e.mutex, which is held by scrapes.
+// It must be called with e.mutex held.
+func (e *Exporter) storePrices() {
+	stations := make([]StationPrices, 0, len(e.cachedPrices))
+	for id, price := range e.cachedPrices {
+		// Stations might have been removed since the prices were retrieved.
+		station, ok := e.stations[id]
+		if !ok {
+			continue
+		}
+
+		location, _ := encodeLocation(station.Lat, station.Lng)
+		s := StationPrices{
+			ID:      id,
+			Name:    e.stationName(id, station),
+			Brand:   e.normalizeName(station.Brand),
+			Geohash: location,
+			Open:    price.Status == "open",
+			Prices:  make(map[string]float64, len(products)),
+		}
+		for _, product := range products {
+			if !e.includesProduct(product) {
+				continue
+			}
+			if v, ok, err := productPrice(price, product); err == nil && ok {
+				s.Prices[product] = e.inPriceUnit(roundPrice(v, e.pricePrecision))
+			}
+		}
+		stations = append(stations, s)
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].ID < stations[j].ID
+	})
+
+	e.snapshot.Store(&pricesSnapshot{stations: stations, at: e.cachedAt})
+}
@@ -0,0 +1,257 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+// search describes a search for stations around a location.
+type search struct {
+	lat, lng float64
+	radius   int
+}
+
+// earthRadius is the mean radius of the earth in kilometers.
+const earthRadius = 6371.0
+
+// distance returns the great-circle distance of the given station from the
+// search location in kilometers.
+func (s *search) distance(station tankerkoenig.Station) float64 {
+	lat1, lat2 := s.lat*math.Pi/180, station.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (station.Lng - s.lng) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// mergeStations merges the pinned stations into the stations found by the
+// location search. The pinned stations take precedence, as they carry their
+// full details, but keep the distance reported by the search if they were
+// found by it.
+func (e *Exporter) mergeStations(found, pinned map[string]tankerkoenig.Station) map[string]tankerkoenig.Station {
+	stations := make(map[string]tankerkoenig.Station, len(found)+len(pinned))
+	for id, station := range found {
+		stations[id] = station
+	}
+	for id, station := range pinned {
+		if f, ok := found[id]; ok {
+			station.Dist = f.Dist
+		} else {
+			station.Dist = e.search.distance(station)
+		}
+		stations[id] = station
+	}
+	return stations
+}
+
+// start launches the background tasks of the exporter. They run until the
+// exporter's context is canceled.
+func (e *Exporter) start() {
+	if e.revalidate {
+		go e.refreshMetadata(e.ctx)
+	}
+	if e.metadataRefresh > 0 {
+		go e.every(e.metadataRefresh, e.refreshMetadata)
+	}
+	if e.locationRefresh > 0 && e.search != nil {
+		go e.every(e.locationRefresh, e.refreshLocation)
+	}
+	if e.pollInterval > 0 {
+		go func() {
+			// Poll right away, so there are prices to serve before the first
+			// interval has passed.
+			e.poll(e.ctx)
+			e.every(e.pollInterval, e.poll)
+		}()
+	}
+}
+
+// every calls f in the given interval until the exporter's context is canceled.
+func (e *Exporter) every(interval time.Duration, f func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			f(e.ctx)
+		}
+	}
+}
+
+// poll retrieves the prices of the monitored stations and caches them to be
+// served by subsequent collects. If the retrieval fails, the current prices
+// are kept.
+func (e *Exporter) poll(ctx context.Context) {
+	e.mutex.RLock()
+	ids := e.stationIDs()
+	e.mutex.RUnlock()
+
+	ctx, span := tracer.Start(ctx, "poll", trace.WithAttributes(attribute.Int("tankerkoenig.stations", len(ids))))
+	defer span.End()
+
+	prices, missing, license, err := e.fetchPrices(ctx, ids)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.lastScrapeOK.Store(err == nil)
+	if err != nil {
+		e.logger.Error("cannot poll tankerkoenig api, keeping current prices", "err", err)
+		return
+	}
+
+	e.dropMissing(prices, missing)
+	e.cachedPrices = prices
+	e.cachedAt = time.Now()
+	e.cachedTraceID = span.SpanContext().TraceID()
+	e.lastSuccess.Set(float64(e.cachedAt.Unix()))
+	e.storePrices()
+	e.observePrices(prices)
+	if license != "" {
+		e.license = license
+	}
+}
+
+// refreshMetadata retrieves the metadata of the monitored stations and replaces
+// the currently known metadata with it. If the retrieval fails, the current
+// metadata is kept.
+func (e *Exporter) refreshMetadata(ctx context.Context) {
+	e.mutex.RLock()
+	ids := make([]string, 0, len(e.stations))
+	for id := range e.stations {
+		ids = append(ids, id)
+	}
+	pinnedIDs := e.pinned
+	e.mutex.RUnlock()
+
+	var (
+		stations map[string]tankerkoenig.Station
+		err      error
+	)
+	if e.search != nil {
+		stations, err = e.searchStations(ctx)
+		if err == nil && len(pinnedIDs) > 0 {
+			var pinned map[string]tankerkoenig.Station
+			if pinned, err = e.stationDetails(ctx, pinnedIDs); err == nil {
+				stations = e.mergeStations(stations, pinned)
+			}
+		}
+	} else {
+		stations, err = e.stationDetails(ctx, ids)
+	}
+	if err != nil {
+		e.logger.Error("cannot refresh station metadata, keeping current metadata", "err", err)
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// Only update the metadata of the monitored stations. Stations entering or
+	// leaving the search radius are not picked up.
+	for _, id := range ids {
+		if station, ok := stations[id]; ok {
+			e.stations[id] = station
+		}
+	}
+	// The prices snapshot carries the names and brands of the stations.
+	e.storePrices()
+}
+
+// refreshLocation searches for stations around the location the exporter was
+// created for again and replaces the monitored stations with the result. This
+// picks up stations that entered or left the search radius. If the search
+// fails, the current stations are kept.
+func (e *Exporter) refreshLocation(ctx context.Context) {
+	stations, err := e.searchStations(ctx)
+	if err != nil {
+		e.logger.Error("cannot refresh stations around location, keeping current stations", "err", err)
+		return
+	}
+	e.excludeStations(stations)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// The pinned stations are monitored regardless of the search, so they are
+	// carried over as they are.
+	pinned := make(map[string]tankerkoenig.Station, len(e.pinned))
+	for _, id := range e.pinned {
+		if station, ok := e.stations[id]; ok {
+			pinned[id] = station
+		}
+	}
+	stations = e.mergeStations(stations, pinned)
+	e.filterBrands(stations)
+
+	e.replaceStations(stations)
+}
+
+// SetStations replaces the stations the exporter was created for with the
+// stations with the given IDs, e.g. after the configuration was reloaded. For
+// exporters created for a location, the stations are monitored in addition to
+// the ones found around the location, which are searched for again. The IDs are
+// validated by retrieving the station details first. If that fails, the current
+// stations are kept and the error is returned.
+func (e *Exporter) SetStations(ctx context.Context, ids []string) error {
+	if len(ids) == 0 && e.search == nil {
+		return errors.New("no stations given")
+	}
+	ids = e.uniqueStations(ids)
+
+	stations, err := e.stationDetails(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if e.search != nil {
+		found, err := e.searchStations(ctx)
+		if err != nil {
+			return err
+		}
+		e.excludeStations(found)
+		stations = e.mergeStations(found, stations)
+	}
+	e.filterBrands(stations)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.search != nil {
+		e.pinned = ids
+	}
+	e.replaceStations(stations)
+
+	return nil
+}
+
+// replaceStations replaces the monitored stations with the given ones and logs
+// the stations that were added or removed. It must be called with e.mutex held.
+func (e *Exporter) replaceStations(stations map[string]tankerkoenig.Station) {
+	for id, station := range stations {
+		if _, ok := e.stations[id]; !ok {
+			e.logger.Info("station added", "station_id", id, "station_name", station.Name)
+		}
+	}
+	for id, station := range e.stations {
+		if _, ok := stations[id]; !ok {
+			e.logger.Info("station removed", "station_id", id, "station_name", station.Name)
+		}
+	}
+
+	e.setStations(stations)
+}
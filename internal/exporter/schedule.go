@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // Stations publish their opening times in German time.
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+// berlin is the time zone the opening times of stations are given in.
+var berlin = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}()
+
+var (
+	daySeparator    = regexp.MustCompile(`[,;/&+]|\bund\b`)
+	exceptSeparator = regexp.MustCompile(`\b(?:ausser|außer)\b`)
+	everyDay        = regexp.MustCompile(`täglich|taeglich|jeden tag`)
+)
+
+// hasSchedule reports whether the opening times of the station are known. They
+// are only available for stations whose details were retrieved.
+func hasSchedule(station tankerkoenig.Station) bool {
+	return station.WholeDay || len(station.OpeningTimes) > 0
+}
+
+// scheduledOpen reports whether the station is open at the given time according
+// to its opening times. Opening times that span midnight, like 22:00 to 06:00,
+// are supported. Public holidays are not taken into account.
+func scheduledOpen(station tankerkoenig.Station, t time.Time) bool {
+	if station.WholeDay {
+		return true
+	}
+
+	t = t.In(berlin)
+	var (
+		now       = t.Hour()*60 + t.Minute()
+		today     = t.Weekday()
+		yesterday = (today + 6) % 7
+	)
+	for _, openingTime := range station.OpeningTimes {
+		start, ok := parseClock(openingTime.Start)
+		if !ok {
+			continue
+		}
+		end, ok := parseClock(openingTime.End)
+		if !ok {
+			continue
+		}
+		days := parseDays(openingTime.Text)
+
+		switch {
+		case start == end:
+			// Open around the clock on the given days.
+			if days[today] {
+				return true
+			}
+		case start < end:
+			if days[today] && now >= start && now < end {
+				return true
+			}
+		default:
+			// The opening time spans midnight, so it either started today
+			// or yesterday.
+			if (days[today] && now >= start) || (days[yesterday] && now < end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseClock parses a time of day in the form of HH:MM or HH:MM:SS and returns
+// the minutes since midnight.
+func parseClock(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 5 {
+		s = s[:5]
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// parseDays parses the German description of the days an opening time applies
+// to, like "Mo-Fr", "Samstag" or "täglich ausser Sonn- und Feiertagen". Days
+// following "ausser" are excluded. Descriptions that only mention public
+// holidays or no known day at all apply to no day, as public holidays are not
+// taken into account.
+func parseDays(text string) [7]bool {
+	text = strings.ToLower(text)
+
+	var (
+		included = text
+		excluded string
+	)
+	if loc := exceptSeparator.FindStringIndex(text); loc != nil {
+		included, excluded = text[:loc[0]], text[loc[1]:]
+	}
+
+	days, found := parseDayList(included)
+	// Descriptions like "ausser Sonntag" imply every other day.
+	if !found && (everyDay.MatchString(included) || strings.TrimSpace(included) == "" && excluded != "") {
+		for d := range days {
+			days[d] = true
+		}
+	}
+
+	except, _ := parseDayList(excluded)
+	for d, ok := range except {
+		if ok {
+			days[d] = false
+		}
+	}
+
+	return days
+}
+
+// parseDayList parses a list of weekdays and ranges of weekdays like
+// "Mo-Fr, Sa". It reports whether any known day was found.
+func parseDayList(text string) ([7]bool, bool) {
+	var (
+		days  [7]bool
+		found bool
+	)
+	for _, part := range daySeparator.Split(text, -1) {
+		from, to, isRange := strings.Cut(part, "-")
+		start, ok := parseDay(from)
+		if !ok {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, ok = parseDay(to); !ok {
+				end = start
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+		found = true
+	}
+	return days, found
+}
+
+// parseDay parses an abbreviated or full German weekday like "Mo" or "Montag".
+func parseDay(s string) (time.Weekday, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0, false
+	}
+	switch s[:2] {
+	case "mo":
+		return time.Monday, true
+	case "di":
+		return time.Tuesday, true
+	case "mi":
+		return time.Wednesday, true
+	case "do":
+		return time.Thursday, true
+	case "fr":
+		return time.Friday, true
+	case "sa":
+		return time.Saturday, true
+	case "so":
+		return time.Sunday, true
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lukasmalkmus/tankerkoenig_exporter/internal/tankerkoenig"
+)
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   int
+		wantOK bool
+	}{
+		{s: "06:00", want: 360, wantOK: true},
+		{s: "22:30:00", want: 1350, wantOK: true},
+		{s: " 00:00:00 ", want: 0, wantOK: true},
+		{s: "23:59:59", want: 1439, wantOK: true},
+		{s: "24:00"},
+		{s: "6 Uhr"},
+		{s: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseClock(tt.s)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseClock(%q) = %d, %t, want %d, %t", tt.s, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	const all = "MTWTFSS"
+	tests := []struct {
+		text string
+		// want are the days the text applies to, from Monday to Sunday.
+		want string
+	}{
+		{text: "täglich", want: all},
+		{text: "Mo-So", want: all},
+		{text: "Mo-Fr", want: "MTWTF.."},
+		{text: "Samstag", want: ".....S."},
+		{text: "Sa, So", want: ".....SS"},
+		{text: "Sa und So", want: ".....SS"},
+		{text: "Fr-Mo", want: "M...FSS"},
+		{text: "Sonn- und Feiertag", want: "......S"},
+		{text: "täglich ausser Sonn- und Feiertagen", want: "MTWTFS."},
+		{text: "Mo-Fr außer Mi", want: "MT.TF.."},
+		{text: "ausser Sonntag", want: "MTWTFS."},
+		{text: "Feiertag", want: "......."},
+		{text: "Öffnungszeiten", want: "......."},
+	}
+	for _, tt := range tests {
+		days := parseDays(tt.text)
+
+		// Formatted from Monday to Sunday, so failures are easy to read.
+		var got []byte
+		for i, c := range []byte("MTWTFSS") {
+			if days[(i+1)%7] {
+				got = append(got, c)
+			} else {
+				got = append(got, '.')
+			}
+		}
+		if string(got) != tt.want {
+			t.Errorf("parseDays(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestScheduledOpen(t *testing.T) {
+	var station tankerkoenig.Station
+	if err := json.Unmarshal([]byte(`{
+		"openingTimes": [
+			{"text": "Mo-Fr", "start": "22:00:00", "end": "06:00:00"},
+			{"text": "Sonntag", "start": "00:00:00", "end": "00:00:00"}
+		]
+	}`), &station); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{name: "friday before opening", t: time.Date(2024, 3, 8, 21, 59, 0, 0, berlin), want: false},
+		{name: "friday night", t: time.Date(2024, 3, 8, 23, 0, 0, 0, berlin), want: true},
+		{name: "saturday morning after friday night", t: time.Date(2024, 3, 9, 5, 59, 0, 0, berlin), want: true},
+		{name: "saturday after closing", t: time.Date(2024, 3, 9, 6, 0, 0, 0, berlin), want: false},
+		{name: "saturday night", t: time.Date(2024, 3, 9, 23, 0, 0, 0, berlin), want: false},
+		{name: "sunday around the clock", t: time.Date(2024, 3, 10, 12, 0, 0, 0, berlin), want: true},
+		{name: "monday morning after sunday", t: time.Date(2024, 3, 11, 3, 0, 0, 0, berlin), want: false},
+		{name: "monday night in utc", t: time.Date(2024, 3, 11, 21, 30, 0, 0, time.UTC), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheduledOpen(station, tt.t); got != tt.want {
+				t.Errorf("scheduledOpen() at %s = %t, want %t", tt.t, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package tankerkoenig is a client for the Tankerkönig-API. It is a fork of
+// github.com/alexruf/tankerkoenig-go, maintained as part of this repository.
+package tankerkoenig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	libraryVersion = "0.1.0"
+	defaultBaseURL = "https://creativecommons.tankerkoenig.de/"
+	userAgent      = "tankerkoenig-go/" + libraryVersion
+	mediaType      = "application/json"
+
+	// apiKeyParam is the query parameter the API key is passed in.
+	apiKeyParam = "apikey"
+)
+
+// Client communicates with Tankerkönig-API.
+type Client struct {
+	// HTTP client used to communicate with API
+	client *http.Client
+
+	// Base URL for API requests
+	BaseURL *url.URL
+
+	// APIKey used for authentication with the API
+	APIKey string
+
+	// User agent for client
+	UserAgent string
+
+	// Services used for communicating with the API
+	Station StationService
+	Prices  PricesService
+}
+
+// Response is a Tankerkönig-API response. This wraps the standard http.Response returned from Tankerkönig-API.
+type Response struct {
+	*http.Response
+
+	// License is the license of the returned data, if reported by the API.
+	License string
+}
+
+// An ErrorResponse reports the error caused by an API request.
+type ErrorResponse struct {
+	// HTTP response that caused this error
+	Response *http.Response
+
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// NewClient returns a new Tankerkönig-API client.
+func NewClient(apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	baseURL, _ := url.Parse(defaultBaseURL)
+
+	c := &Client{client: httpClient, BaseURL: baseURL, APIKey: apiKey, UserAgent: userAgent}
+	c.Station = &StationServiceOp{client: c}
+	c.Prices = &PricesServiceOp{client: c}
+
+	return c
+}
+
+// NewRequest creates an API request. A relative URL can be provided in urlStr, which will be resolved to the
+// BaseURL of the Client. Relative URLs should always be specified without a preceding slash. If specified, the
+// value pointed to by body is JSON encoded and included in as the request body.
+func (c *Client) NewRequest(method, urlStr string, query url.Values, body interface{}) (*http.Request, error) {
+	return c.NewRequestWithContext(context.Background(), method, urlStr, query, body)
+}
+
+// NewRequestWithContext is like NewRequest but the returned request is bound to the given context.
+func (c *Client) NewRequestWithContext(ctx context.Context, method, urlStr string, query url.Values, body interface{}) (*http.Request, error) {
+	rel, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	u := c.BaseURL.ResolveReference(rel)
+
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	buf := new(bytes.Buffer)
+	if body != nil {
+		err = json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", mediaType)
+	req.Header.Add("Accept", mediaType)
+	ua := c.UserAgent
+	if ua == "" {
+		ua = userAgent
+	}
+	req.Header.Add("User-Agent", ua)
+	return req, nil
+}
+
+// newResponse creates a new Response for the provided http.Response
+func newResponse(r *http.Response) *Response {
+	response := Response{Response: r}
+
+	return &response
+}
+
+// Do sends an API request and returns the API response. The API response is JSON decoded and stored in the value
+// pointed to by v, or returned as an error if an API error has occurred. If v implements the io.Writer interface,
+// the raw response will be written to v, without attempting to decode it.
+func (c *Client) Do(req *http.Request, v interface{}) (*Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The error contains the request URL, which must not leak the API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			if u, perr := url.Parse(urlErr.URL); perr == nil {
+				urlErr.URL = redactURL(u)
+			}
+		}
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	response := newResponse(resp)
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return response, err
+	}
+
+	if v != nil {
+		if w, ok := v.(io.Writer); ok {
+			_, err = io.Copy(w, resp.Body)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = json.NewDecoder(response.Body).Decode(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return response, err
+}
+
+// Error implements the error interface.
+func (r *ErrorResponse) Error() string {
+	return fmt.Sprintf("%v %v: %d %v", r.Response.Request.Method, redactURL(r.Response.Request.URL), r.Response.StatusCode, r.Message)
+}
+
+// redactURL returns the given URL with the API key replaced, so it can be
+// safely included in errors.
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	if !query.Has(apiKeyParam) {
+		return u.String()
+	}
+	query.Set(apiKeyParam, "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// newErrorResponse returns the error for a response the API marked as not ok,
+// which it does with a successful status code.
+func newErrorResponse(r *Response, message string) *ErrorResponse {
+	return &ErrorResponse{Response: r.Response, Message: message}
+}
+
+// CheckResponse checks the API response for errors, and returns them if present. A response is considered an
+// error if it has a status code outside the 200 range. API error responses are expected to have either no response
+// body, or a JSON response body that maps to ErrorResponse. Any other response body will be silently ignored.
+func CheckResponse(r *http.Response) error {
+	if c := r.StatusCode; c >= 200 && c <= 299 {
+		return nil
+	}
+
+	errorResponse := &ErrorResponse{Response: r}
+	data, err := io.ReadAll(r.Body)
+	if err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, errorResponse); err != nil {
+			return err
+		}
+	}
+
+	return errorResponse
+}
@@ -0,0 +1,37 @@
+package tankerkoenig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// setup starts a test server that serves the requests of the returned client
+// with the returned mux. The server is closed once the test finished.
+func setup(t *testing.T) (*http.ServeMux, *Client) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := NewClient("test-key", server.Client())
+	baseURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = baseURL
+
+	return mux, client
+}
+
+// testQuery fails the test if the query parameter of the request with the
+// given key doesn't have the given value.
+func testQuery(t *testing.T, r *http.Request, key, want string) {
+	t.Helper()
+
+	if got := r.URL.Query().Get(key); got != want {
+		t.Errorf("query parameter %q = %q, want %q", key, got, want)
+	}
+}
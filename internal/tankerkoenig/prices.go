@@ -0,0 +1,78 @@
+package tankerkoenig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PricesService is an interface to query price information from the Tankerkönig-API.
+type PricesService interface {
+	// Get returns a map of Price items for one or multiple station IDs.
+	Get(ids ...string) (map[string]Price, *Response, error)
+	// GetWithContext is like Get but aborts the request when the context is canceled.
+	GetWithContext(ctx context.Context, ids ...string) (map[string]Price, *Response, error)
+}
+
+// PricesServiceOp handles communication with the price related methods of the Tankerkönig-API.
+type PricesServiceOp struct {
+	client *Client
+}
+
+var _ PricesService = &PricesServiceOp{}
+
+// Price represents a price data structure.
+type Price struct {
+	Status string      `json:"status"` // Open-status
+	Diesel interface{} `json:"diesel"` // Price for diesel fuel type
+	E5     interface{} `json:"e5"`     // Price for E5 fuel type
+	E10    interface{} `json:"e10"`    // Price for E10 fuel type
+}
+
+// pricesRoot represents a response from the Tankerkönig-API.
+type pricesRoot struct {
+	Ok      bool             `json:"ok"`
+	Message string           `json:"message"`
+	License string           `json:"license"`
+	Data    string           `json:"data"`
+	Prices  map[string]Price `json:"prices"`
+}
+
+// Get implements PricesService.
+func (p *PricesServiceOp) Get(ids ...string) (map[string]Price, *Response, error) {
+	return p.GetWithContext(context.Background(), ids...)
+}
+
+// GetWithContext implements PricesService.
+func (p *PricesServiceOp) GetWithContext(ctx context.Context, ids ...string) (map[string]Price, *Response, error) {
+	path := "json/prices.php"
+
+	// Quote the IDs into a new slice, the callers slice must not be modified.
+	quoted := make([]string, len(ids))
+	for n, id := range ids {
+		quoted[n] = fmt.Sprintf("%q", id)
+	}
+
+	query := url.Values{}
+	query.Add("ids", fmt.Sprintf("[%s]", strings.Join(quoted, ",")))
+	query.Add(apiKeyParam, p.client.APIKey)
+
+	req, err := p.client.NewRequestWithContext(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := new(pricesRoot)
+	resp, err := p.client.Do(req, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !root.Ok {
+		return nil, nil, newErrorResponse(resp, root.Message)
+	}
+	resp.License = root.License
+
+	return root.Prices, resp, nil
+}
@@ -0,0 +1,68 @@
+package tankerkoenig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPricesService_GetWithContext_ConcurrentBatches(t *testing.T) {
+	mux, client := setup(t)
+
+	mux.HandleFunc("/json/prices.php", func(w http.ResponseWriter, r *http.Request) {
+		testQuery(t, r, "apikey", "test-key")
+
+		var ids []string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("ids")), &ids); err != nil {
+			t.Errorf("invalid ids %q: %v", r.URL.Query().Get("ids"), err)
+		}
+		prices := make(map[string]Price, len(ids))
+		for _, id := range ids {
+			prices[id] = Price{Status: "open", E5: 1.789}
+		}
+		_ = json.NewEncoder(w).Encode(pricesRoot{Ok: true, Prices: prices})
+	})
+
+	// Batches are sub slices of the same slice, like the exporter retrieves
+	// them.
+	ids := make([]string, 25)
+	for n := range ids {
+		ids[n] = fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+	}
+	want := slices.Clone(ids)
+
+	const batchSize = 10
+	var wg sync.WaitGroup
+	for round := 0; round < 10; round++ {
+		for i := 0; i < len(ids); i += batchSize {
+			batch := ids[i:min(i+batchSize, len(ids))]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				prices, _, err := client.Prices.GetWithContext(context.Background(), batch...)
+				if err != nil {
+					t.Errorf("GetWithContext() = %v", err)
+					return
+				}
+				if len(prices) != len(batch) {
+					t.Errorf("GetWithContext() returned %d prices, want %d", len(prices), len(batch))
+				}
+				for _, id := range batch {
+					if _, ok := prices[id]; !ok {
+						t.Errorf("GetWithContext() is missing the price of station %s", id)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if !slices.Equal(ids, want) {
+		t.Errorf("GetWithContext() modified the given ids: %v", ids)
+	}
+}
@@ -0,0 +1,187 @@
+package tankerkoenig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// StationService is an interface to query station information from the Tankerkönig-API.
+type StationService interface {
+	// Detail returns the station for the given ID
+	Detail(id string) (Station, *Response, error)
+	// DetailWithContext is like Detail but aborts the request when the context is canceled.
+	DetailWithContext(ctx context.Context, id string) (Station, *Response, error)
+	// List returns all stations within a radius of a location.
+	List(lat float64, lng float64, rad int) ([]Station, *Response, error)
+	// ListWithContext is like List but aborts the request when the context is canceled.
+	ListWithContext(ctx context.Context, lat float64, lng float64, rad int) ([]Station, *Response, error)
+	// ListByType returns the stations within a radius of a location offering the given fuel type,
+	// which is one of "e5", "e10", "diesel" or "all".
+	ListByType(lat float64, lng float64, rad int, fuelType string) ([]Station, *Response, error)
+	// ListByTypeWithContext is like ListByType but aborts the request when the context is canceled.
+	ListByTypeWithContext(ctx context.Context, lat float64, lng float64, rad int, fuelType string) ([]Station, *Response, error)
+	// ListSorted is like ListByType but sorts the stations by the given order, which is one of
+	// "dist" or "price". Sorting by price requires a fuel type other than "all".
+	ListSorted(lat float64, lng float64, rad int, fuelType string, sort string) ([]Station, *Response, error)
+	// ListSortedWithContext is like ListSorted but aborts the request when the context is canceled.
+	ListSortedWithContext(ctx context.Context, lat float64, lng float64, rad int, fuelType string, sort string) ([]Station, *Response, error)
+}
+
+// StationServiceOp handles communication with the station related methods of the Tankerkönig-API.
+type StationServiceOp struct {
+	client *Client
+}
+
+var _ StationService = &StationServiceOp{}
+
+// Station represents a gas station.
+type Station struct {
+	Brand       string      `json:"brand"`       // Brand
+	Dist        float64     `json:"dist"`        // Distance (air line) from the search point to the gas station
+	HouseNumber string      `json:"houseNumber"` // House number
+	ID          string      `json:"id"`          // ID
+	IsOpen      bool        `json:"isOpen"`      // Open-status
+	Lat         float64     `json:"lat"`         // Latitude
+	Lng         float64     `json:"lng"`         // Longitude
+	Name        string      `json:"name"`        // Name
+	Place       string      `json:"place"`       // Place
+	PostCode    int         `json:"postCode"`    // Post code
+	Diesel      interface{} `json:"diesel"`      // Price for diesel fuel type
+	E5          interface{} `json:"e5"`          // Price for E5 fuel type
+	E10         interface{} `json:"e10"`         // Price for E10 fuel type
+	Street      string      `json:"street"`      // Street
+
+	// Price is the price for the fuel type the stations were listed by. Only
+	// reported by the API if it is not "all" and also set as the price of the
+	// corresponding fuel type.
+	Price interface{} `json:"price"`
+
+	// Following properties are only available, when Detail() was called
+
+	Overrides    []string      `json:"overrides"`
+	WholeDay     bool          `json:"wholeDay"`
+	State        string        `json:"state"`
+	OpeningTimes []openingTime `json:"openingTimes"`
+}
+
+// openingTime represents an opening time of the station.
+type openingTime struct {
+	Text  string `json:"text"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// stationsRoot represents a response from the Tankerkönig-API.
+type stationsRoot struct {
+	Status  string `json:"status"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+	License string `json:"license"`
+	Data    string `json:"data"`
+
+	// Stations is available when List() was called
+	Stations []Station `json:"stations"`
+
+	// Station is available when Detail() was called
+	Station Station `json:"station"`
+}
+
+// Detail implements StationService.
+func (s *StationServiceOp) Detail(id string) (Station, *Response, error) {
+	return s.DetailWithContext(context.Background(), id)
+}
+
+// DetailWithContext implements StationService.
+func (s *StationServiceOp) DetailWithContext(ctx context.Context, id string) (Station, *Response, error) {
+	path := "json/detail.php"
+
+	query := url.Values{}
+	query.Add("id", id)
+	query.Add(apiKeyParam, s.client.APIKey)
+
+	req, err := s.client.NewRequestWithContext(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return Station{}, nil, err
+	}
+
+	root := new(stationsRoot)
+	resp, err := s.client.Do(req, root)
+	if err != nil {
+		return Station{}, nil, err
+	}
+	if !root.Ok {
+		return Station{}, nil, newErrorResponse(resp, root.Message)
+	}
+	resp.License = root.License
+
+	return root.Station, resp, nil
+}
+
+// List implements StationService.
+func (s *StationServiceOp) List(lat float64, lng float64, rad int) ([]Station, *Response, error) {
+	return s.ListWithContext(context.Background(), lat, lng, rad)
+}
+
+// ListWithContext implements StationService.
+func (s *StationServiceOp) ListWithContext(ctx context.Context, lat float64, lng float64, rad int) ([]Station, *Response, error) {
+	return s.ListByTypeWithContext(ctx, lat, lng, rad, "all")
+}
+
+// ListByType implements StationService.
+func (s *StationServiceOp) ListByType(lat float64, lng float64, rad int, fuelType string) ([]Station, *Response, error) {
+	return s.ListByTypeWithContext(context.Background(), lat, lng, rad, fuelType)
+}
+
+// ListByTypeWithContext implements StationService.
+func (s *StationServiceOp) ListByTypeWithContext(ctx context.Context, lat float64, lng float64, rad int, fuelType string) ([]Station, *Response, error) {
+	return s.ListSortedWithContext(ctx, lat, lng, rad, fuelType, "dist")
+}
+
+// ListSorted implements StationService.
+func (s *StationServiceOp) ListSorted(lat float64, lng float64, rad int, fuelType string, sort string) ([]Station, *Response, error) {
+	return s.ListSortedWithContext(context.Background(), lat, lng, rad, fuelType, sort)
+}
+
+// ListSortedWithContext implements StationService.
+func (s *StationServiceOp) ListSortedWithContext(ctx context.Context, lat float64, lng float64, rad int, fuelType string, sort string) ([]Station, *Response, error) {
+	path := "json/list.php"
+
+	query := url.Values{}
+	query.Add("lat", fmt.Sprintf("%.13f", lat))
+	query.Add("lng", fmt.Sprintf("%.13f", lng))
+	query.Add("rad", fmt.Sprintf("%d", rad))
+	query.Add("type", fuelType)
+	query.Add(apiKeyParam, s.client.APIKey)
+	query.Add("sort", sort)
+
+	req, err := s.client.NewRequestWithContext(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := new(stationsRoot)
+	resp, err := s.client.Do(req, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !root.Ok {
+		return nil, nil, newErrorResponse(resp, root.Message)
+	}
+	resp.License = root.License
+
+	// The price of a single fuel type is reported separately.
+	for i := range root.Stations {
+		switch fuelType {
+		case "e5":
+			root.Stations[i].E5 = root.Stations[i].Price
+		case "e10":
+			root.Stations[i].E10 = root.Stations[i].Price
+		case "diesel":
+			root.Stations[i].Diesel = root.Stations[i].Price
+		}
+	}
+
+	return root.Stations, resp, nil
+}
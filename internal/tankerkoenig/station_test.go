@@ -0,0 +1,119 @@
+package tankerkoenig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// serveFile serves the file from testdata with the given name.
+func serveFile(t *testing.T, name string) http.HandlerFunc {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}
+}
+
+func TestStationService_DetailWithContext(t *testing.T) {
+	mux, client := setup(t)
+
+	mux.HandleFunc("/json/detail.php", func(w http.ResponseWriter, r *http.Request) {
+		testQuery(t, r, "id", "24a381e3-0d72-416d-bfd8-b2f65f6e5802")
+		testQuery(t, r, "apikey", "test-key")
+		serveFile(t, "detail.json")(w, r)
+	})
+
+	station, resp, err := client.Station.DetailWithContext(context.Background(), "24a381e3-0d72-416d-bfd8-b2f65f6e5802")
+	if err != nil {
+		t.Fatalf("DetailWithContext() = %v", err)
+	}
+
+	want := Station{
+		Brand:       "ESSO",
+		HouseNumber: " ",
+		ID:          "24a381e3-0d72-416d-bfd8-b2f65f6e5802",
+		Lat:         48.72210601,
+		Lng:         12.44438439,
+		Name:        "Esso Tankstelle",
+		Place:       "MENGKOFEN",
+		PostCode:    84152,
+		Diesel:      1.169,
+		E5:          1.379,
+		E10:         1.359,
+		Street:      "HAUPTSTR. 7",
+		Overrides:   []string{},
+		OpeningTimes: []openingTime{
+			{Text: "Mo-Fr", Start: "06:00:00", End: "22:30:00"},
+			{Text: "Samstag", Start: "07:00:00", End: "22:00:00"},
+			{Text: "Sonntag", Start: "08:00:00", End: "22:00:00"},
+		},
+	}
+	if !reflect.DeepEqual(station, want) {
+		t.Errorf("DetailWithContext() = %+v, want %+v", station, want)
+	}
+	if want := "CC BY 4.0 -  https://creativecommons.tankerkoenig.de"; resp.License != want {
+		t.Errorf("DetailWithContext() license = %q, want %q", resp.License, want)
+	}
+}
+
+func TestStationService_ListWithContext(t *testing.T) {
+	mux, client := setup(t)
+
+	mux.HandleFunc("/json/list.php", func(w http.ResponseWriter, r *http.Request) {
+		testQuery(t, r, "lat", "52.5210000000000")
+		testQuery(t, r, "lng", "13.4380000000000")
+		testQuery(t, r, "rad", "2")
+		serveFile(t, "list.json")(w, r)
+	})
+
+	stations, _, err := client.Station.ListWithContext(context.Background(), 52.521, 13.438, 2)
+	if err != nil {
+		t.Fatalf("ListWithContext() = %v", err)
+	}
+
+	want := []Station{
+		{
+			Brand:       "TOTAL",
+			Dist:        1.1,
+			HouseNumber: "2",
+			ID:          "474e5046-deaf-4f9b-9a32-9797b778f047",
+			IsOpen:      true,
+			Lat:         52.53083,
+			Lng:         13.440946,
+			Name:        "TOTAL BERLIN",
+			Place:       "BERLIN",
+			PostCode:    10407,
+			Diesel:      1.109,
+			E5:          1.339,
+			E10:         1.319,
+			Street:      "MARGARETE-SOMMER-STR.",
+		},
+		{
+			Brand:       "ARAL",
+			Dist:        1.7,
+			HouseNumber: "12",
+			ID:          "278130b1-e062-4a0f-80cc-19e486b4c024",
+			IsOpen:      true,
+			Lat:         52.51202,
+			Lng:         13.42078,
+			Name:        "Aral Tankstelle",
+			Place:       "Berlin",
+			PostCode:    10179,
+			Diesel:      1.109,
+			E5:          1.349,
+			E10:         false,
+			Street:      "Holzmarktstraße",
+		},
+	}
+	if !reflect.DeepEqual(stations, want) {
+		t.Errorf("ListWithContext() = %+v, want %+v", stations, want)
+	}
+}